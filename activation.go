@@ -0,0 +1,171 @@
+package soju
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Names of the socket activation environment variables set by systemd.
+const (
+	ListenPidEnv     = "LISTEN_PID"
+	ListenFdsEnv     = "LISTEN_FDS"
+	ListenFdNamesEnv = "LISTEN_FDNAMES"
+)
+
+// The first inherited file descriptor, after stdin, stdout and stderr.
+const listenFdsStart = 3
+
+// ErrNoActivation is returned when the process was not socket activated (nor
+// upgraded, see Server.Upgrade).
+var ErrNoActivation = errors.New("soju: no sockets passed by socket activation")
+
+// The inherited files are read once, the listeners are built on copies.
+var (
+	activationOnce  sync.Once
+	activationFiles []*os.File
+)
+
+// Returns the number of inherited sockets and their names (the file
+// descriptor number if unnamed) from the environment variables values.
+func parseActivationEnv(pid, fds, names string) (int, []string) {
+
+	if pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n <= 0 {
+		return 0, nil
+	}
+
+	parsed := strings.Split(names, ":")
+	fdNames := make([]string, n)
+	for i := range fdNames {
+		if i < len(parsed) && parsed[i] != "" {
+			fdNames[i] = parsed[i]
+		} else {
+			fdNames[i] = strconv.Itoa(listenFdsStart + i)
+		}
+	}
+
+	return n, fdNames
+
+}
+
+// Returns the files inherited through socket activation or, if there is none,
+// from the parent process on an upgrade.
+func inheritedFiles() []*os.File {
+
+	activationOnce.Do(func() {
+
+		n, names := parseActivationEnv(
+			os.Getenv(ListenPidEnv),
+			os.Getenv(ListenFdsEnv),
+			os.Getenv(ListenFdNamesEnv),
+		)
+
+		for i := 0; i < n; i++ {
+			fd := listenFdsStart + i
+			// Do not leak the sockets to child processes.
+			syscall.CloseOnExec(fd)
+			activationFiles = append(activationFiles, os.NewFile(uintptr(fd), names[i]))
+		}
+
+		if len(activationFiles) == 0 {
+			activationFiles = upgradeFiles
+		}
+
+	})
+
+	return activationFiles
+
+}
+
+// Wraps a listener for each of the files in a WaitListener sharing the given
+// WaitGroup. The files are not closed.
+func listenersFromFiles(files []*os.File, wg *sync.WaitGroup) ([]*WaitListener, error) {
+
+	listeners := make([]*WaitListener, 0, len(files))
+	for _, f := range files {
+
+		l, err := net.FileListener(f)
+		if err != nil {
+			for _, wl := range listeners {
+				wl.Close()
+			}
+			return nil, fmt.Errorf("soju: file %s is not a listener: %v", f.Name(), err)
+		}
+
+		listeners = append(listeners, &WaitListener{
+			Listener:  l,
+			WaitGroup: wg,
+		})
+
+	}
+
+	return listeners, nil
+
+}
+
+// ActivationListeners returns a WaitListener, sharing the given WaitGroup, for
+// every socket passed by systemd socket activation (LISTEN_FDS and LISTEN_PID)
+// in order. It returns ErrNoActivation if there is none.
+func ActivationListeners(wg *sync.WaitGroup) ([]*WaitListener, error) {
+
+	files := inheritedFiles()
+	if len(files) == 0 {
+		return nil, ErrNoActivation
+	}
+
+	return listenersFromFiles(files, wg)
+
+}
+
+// NamedActivationListeners is like ActivationListeners but the listeners are
+// grouped by their name in LISTEN_FDNAMES (FileDescriptorName= in the socket
+// unit). Unnamed sockets are named after their file descriptor number.
+func NamedActivationListeners(wg *sync.WaitGroup) (map[string][]*WaitListener, error) {
+
+	listeners, err := ActivationListeners(wg)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupByName(inheritedFiles(), listeners), nil
+
+}
+
+func groupByName(files []*os.File, listeners []*WaitListener) map[string][]*WaitListener {
+
+	named := make(map[string][]*WaitListener)
+	for i := range listeners {
+		named[files[i].Name()] = append(named[files[i].Name()], listeners[i])
+	}
+
+	return named
+
+}
+
+// ActivationListener returns a WaitListener for the first socket passed by
+// systemd socket activation with the given name.
+func ActivationListener(name string, wg *sync.WaitGroup) (*WaitListener, error) {
+
+	for _, f := range inheritedFiles() {
+		if f.Name() == name {
+			listeners, err := listenersFromFiles([]*os.File{f}, wg)
+			if err != nil {
+				return nil, err
+			}
+			return listeners[0], nil
+		}
+	}
+
+	return nil, fmt.Errorf("soju: no socket named %s passed by socket activation", name)
+
+}
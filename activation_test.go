@@ -0,0 +1,116 @@
+package soju
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// Only the environment set for this process is used.
+func TestParseActivationEnv(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	if n, _ := parseActivationEnv("1", "2", ""); n != 0 {
+		t.Errorf("the sockets are for another process but got %d", n)
+	}
+	if n, _ := parseActivationEnv(pid, "", ""); n != 0 {
+		t.Errorf("no sockets were passed but got %d", n)
+	}
+
+	n, names := parseActivationEnv(pid, "3", "http:admin")
+	if n != 3 {
+		t.Errorf("3 sockets were passed but got %d", n)
+		return
+	}
+	expected := []string{"http", "admin", "5"}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("expected names %v but got %v", expected, names)
+			return
+		}
+	}
+}
+
+// Builds WaitListeners from inherited listener files
+// and groups them by name.
+func TestListenersFromFiles(t *testing.T) {
+	var files []*os.File
+	for _, name := range []string{"http", "admin", "http"} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// File() returns a copy of the socket, like the inherited ones.
+		f, err := l.(*net.TCPListener).File()
+		l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fd, err := syscall.Dup(int(f.Fd()))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		named := os.NewFile(uintptr(fd), name)
+		defer named.Close()
+		files = append(files, named)
+	}
+
+	wg := new(sync.WaitGroup)
+	listeners, err := listenersFromFiles(files, wg)
+	if err != nil {
+		t.Errorf("listenersFromFiles() should return nil but returned [%v]", err)
+		return
+	}
+	defer func() {
+		for _, wl := range listeners {
+			wl.Close()
+		}
+	}()
+
+	named := groupByName(files, listeners)
+	if len(named["http"]) != 2 || len(named["admin"]) != 1 {
+		t.Errorf("unexpected named listeners %v", named)
+		return
+	}
+
+	// The inherited listener works as a WaitListener.
+	go func() {
+		c, err := net.Dial("tcp", named["admin"][0].Addr().String())
+		if err == nil {
+			c.Close()
+		}
+	}()
+	conn, err := named["admin"][0].Accept()
+	if err != nil {
+		t.Errorf("Accept() should return nil but returned [%v]", err)
+		return
+	}
+	conn.Close()
+	wg.Wait()
+}
+
+// A file that is not a socket fails.
+func TestListenersFromFilesNotSocket(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := listenersFromFiles([]*os.File{f}, new(sync.WaitGroup)); err == nil {
+		t.Errorf("listenersFromFiles() should fail for %s", os.DevNull)
+	}
+}
+
+// Without socket activation there are no listeners.
+func TestNoActivation(t *testing.T) {
+	if _, err := ActivationListeners(new(sync.WaitGroup)); err != ErrNoActivation {
+		t.Errorf("ActivationListeners() should return ErrNoActivation but returned [%v]", err)
+	}
+	if _, err := ActivationListener("http", new(sync.WaitGroup)); err == nil {
+		t.Errorf("ActivationListener() should fail without socket activation")
+	}
+}
@@ -0,0 +1,99 @@
+package soju
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the time and waits for it, so that it can be faked in tests.
+type Clock interface {
+	Now() time.Time
+	// Like time.After.
+	After(time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock of the operating system.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Sets the clock driving the timeouts of the server, SystemClock by default.
+// Faking it lets tests drive the stop, stop now, start and upgrade timeouts,
+// and the restart backoffs, without waiting for them.
+func (s *Server) SetClock(clock Clock) {
+	s.clock = clock
+	return
+}
+
+// Returns the current time of the server clock.
+func (s *Server) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// Waits for the duration on the server clock.
+func (s *Server) after(d time.Duration) <-chan time.Time {
+	if s.clock == nil {
+		return time.After(d)
+	}
+	return s.clock.After(d)
+}
+
+// Like context.WithTimeout but the timeout expires on the server clock.
+func (s *Server) withTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+
+	if s.clock == nil || s.clock == SystemClock {
+		return context.WithTimeout(parent, timeout)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	cc := &clockContext{Context: ctx, deadline: s.clock.Now().Add(timeout)}
+
+	expired := s.clock.After(timeout)
+	go func() {
+		select {
+		case <-expired:
+			atomic.StoreInt32(&cc.expired, 1)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return cc, cancel
+
+}
+
+// A context cancelled when its deadline expires on a Clock.
+type clockContext struct {
+	context.Context
+	deadline time.Time
+	expired  int32
+}
+
+func (cc *clockContext) Deadline() (time.Time, bool) {
+	return cc.deadline, true
+}
+
+func (cc *clockContext) Err() error {
+	if atomic.LoadInt32(&cc.expired) == 1 {
+		return context.DeadlineExceeded
+	}
+	return cc.Context.Err()
+}
+
+// Sets the clock of the default static server.
+func SetClock(clock Clock) {
+	defaultSojuServer.SetClock(clock)
+	return
+}
@@ -0,0 +1,129 @@
+package soju
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tekii/soju/sojutest"
+)
+
+// A service never finishing, telling which stop methods are called.
+type hangingService struct {
+	calls chan string
+}
+
+func (hs *hangingService) Start() (err error) {
+	return
+}
+func (hs *hangingService) Reconfigure() (err error) {
+	return
+}
+func (hs *hangingService) Stop(dn DoneNotifier) (err error) {
+	hs.calls <- "Stop"
+	return
+}
+func (hs *hangingService) StopNow(dn DoneNotifier) (err error) {
+	hs.calls <- "StopNow"
+	return
+}
+
+// Runs the server in background, beginning the shutdown at once.
+func runOnClock(server *Server) (<-chan *ShutdownReport, <-chan error) {
+	reports := make(chan *ShutdownReport, 1)
+	errs := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go func() {
+		report, err := server.RunReport(ctx)
+		reports <- report
+		errs <- err
+	}()
+	return reports, errs
+}
+
+// The service never finishes
+// Stop and StopNow are given an hour each on the fake clock
+func TestClockStopTimeouts(t *testing.T) {
+	clock := sojutest.NewFakeClock(epoch)
+	server := new(Server)
+	server.SetClock(clock)
+	server.SetTimeouts(time.Hour, time.Hour)
+	service := &hangingService{calls: make(chan string, 2)}
+	server.SetService(service)
+
+	reports, errs := runOnClock(server)
+
+	if call := <-service.calls; call != "Stop" {
+		t.Errorf("Stop() should be called but %s() was", call)
+		return
+	}
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Minute)
+	select {
+	case <-reports:
+		t.Errorf("the shutdown shouldn't finish before the stop timeout")
+		return
+	default:
+	}
+	clock.Advance(time.Minute)
+
+	if call := <-service.calls; call != "StopNow" {
+		t.Errorf("StopNow() should be called but %s() was", call)
+		return
+	}
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+
+	report := <-reports
+	if err := <-errs; err != ErrTimeout {
+		t.Errorf("Run() should return ErrTimeout but returned [%v]", err)
+		return
+	}
+	if !report.Start.Equal(epoch) || report.Duration != 2*time.Hour {
+		t.Errorf("the shutdown should last 2h since %s but lasted %s since %s", epoch, report.Duration, report.Start)
+		return
+	}
+	if c := report.Components[0]; c.Phase != PhaseTimeout || c.Duration != 2*time.Hour {
+		t.Errorf("the service should time out after 2h but its report is %+v", c)
+	}
+}
+
+// A worker fails twice
+// It is restarted after each backoff expires on the fake clock
+func TestClockRestartBackoff(t *testing.T) {
+	clock := sojutest.NewFakeClock(epoch)
+	server := new(Server)
+	server.SetClock(clock)
+	server.SetTimeouts(time.Hour, time.Hour)
+	server.SetService(new(sojuTest))
+	worker := newRunnerWorker(2, errors.New("failed"))
+	server.AddWorker(worker, Restart(RestartOnFailure), RestartBackoff(time.Hour, 10*time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+
+	// The second backoff is doubled.
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	if worker.Runs() != 2 {
+		t.Errorf("the worker should run 2 times but ran %d", worker.Runs())
+		return
+	}
+	clock.Advance(time.Hour)
+	waitRuns(worker, 3)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() should return nil but returned [%v]", err)
+	}
+}
@@ -0,0 +1,136 @@
+package soju
+
+import (
+	"context"
+)
+
+// ContextWorker is the context aware variant of Worker. Instead of informing
+// through a DoneNotifier, Stop and StopNow block until the worker has finished.
+// The context deadline is the stop (or stop now) timeout of the server.
+type ContextWorker interface {
+	Stop(context.Context) error
+	StopNow(context.Context) error
+}
+
+// ContextService is the context aware variant of Service.
+type ContextService interface {
+	Reconfigure() error
+	Start(context.Context) error
+	Stop(context.Context) error
+	StopNow(context.Context) error
+}
+
+// AdaptWorker wraps a DoneNotifier based Worker in a ContextWorker. The
+// returned Stop and StopNow block until the worker calls Done() or the
+// context expires.
+func AdaptWorker(worker Worker) ContextWorker {
+	return &workerAdapter{Worker: worker}
+}
+
+// AdaptService wraps a DoneNotifier based Service in a ContextService.
+func AdaptService(service Service) ContextService {
+	return &serviceAdapter{Service: service}
+}
+
+type workerAdapter struct {
+	Worker
+}
+
+func (wa *workerAdapter) Stop(ctx context.Context) error {
+	return waitDone(ctx, wa.Worker.Stop)
+}
+
+func (wa *workerAdapter) StopNow(ctx context.Context) error {
+	return waitDone(ctx, wa.Worker.StopNow)
+}
+
+type serviceAdapter struct {
+	Service
+}
+
+func (sa *serviceAdapter) Start(ctx context.Context) error {
+	return callContext(ctx, sa.Service.Start)
+}
+
+func (sa *serviceAdapter) Stop(ctx context.Context) error {
+	return waitDone(ctx, sa.Service.Stop)
+}
+
+func (sa *serviceAdapter) StopNow(ctx context.Context) error {
+	return waitDone(ctx, sa.Service.StopNow)
+}
+
+// Returns the Worker or Service wrapped by an adapter, or the component itself
+// if it is not an adapter.
+func unwrap(component interface{}) interface{} {
+	switch a := component.(type) {
+	case *workerAdapter:
+		return a.Worker
+	case *serviceAdapter:
+		return a.Service
+	}
+	return component
+}
+
+// Calls a blocking method in a goroutine and waits until it returns or the
+// context expires, in which case it returns the context error.
+func callContext(ctx context.Context, method func() error) error {
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- method()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+}
+
+// Calls a DoneNotifier based stop method and blocks until Done() is called or
+// the context expires. The returned error is the one returned by the stop
+// method or, if it did not return before the timeout, the context error.
+func waitDone(ctx context.Context, stop func(DoneNotifier) error) error {
+
+	dn := newDoneNotifier()
+
+	// Stop methods must be called in a goroutine, they are allowed to block
+	// until they are done.
+	errc := make(chan error, 1)
+	go func() {
+		errc <- stop(dn)
+	}()
+
+	// The stop method may return (even with an error) before calling Done(),
+	// the worker is not done until it calls it.
+	var err error
+	returned := false
+	for {
+		select {
+		case <-dn.done:
+			if returned {
+				return err
+			}
+			// Done() has been called, wait for the stop method to return its error.
+			select {
+			case err = <-errc:
+				return err
+			case <-ctx.Done():
+				// Done but still running, there is no error to report.
+				return nil
+			}
+		case err = <-errc:
+			returned = true
+			errc = nil
+		case <-ctx.Done():
+			if err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+
+}
@@ -0,0 +1,109 @@
+package soju
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type contextWorkerSample struct {
+	StopCalled, StopNowCalled bool
+	// Time left until the context deadline when Stop was called.
+	StopDeadline time.Duration
+	// If true, Stop blocks until the context expires.
+	Hang bool
+}
+
+func (cws *contextWorkerSample) Stop(ctx context.Context) (err error) {
+	cws.StopCalled = true
+	deadline, _ := ctx.Deadline()
+	cws.StopDeadline = time.Until(deadline)
+	if cws.Hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return
+}
+func (cws *contextWorkerSample) StopNow(ctx context.Context) (err error) {
+	cws.StopNowCalled = true
+	return
+}
+
+// Registers a service and a context aware worker
+// Cancels the context
+// Stops both gracefully
+// returns nil
+func TestRunCancelContext(t *testing.T) {
+	server := new(Server)
+	notificable := new(sojuTest)
+	server.SetService(notificable)
+	w := new(contextWorkerSample)
+	server.AddContextWorker(w)
+	server.SetTimeouts(1*time.Second, 500*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+	}()
+	if err := server.Run(ctx); err != nil {
+		t.Errorf("Run() should return nil but returned [%v]", err)
+		return
+	}
+	if !notificable.StopCalled || notificable.StopNowCalled {
+		t.Errorf("the service should be stopped gracefully only")
+		return
+	}
+	if !w.StopCalled || w.StopNowCalled {
+		t.Errorf("the worker should be stopped gracefully only")
+		return
+	}
+	if w.StopDeadline <= 500*time.Millisecond || w.StopDeadline > 1*time.Second {
+		t.Errorf("the Stop() context deadline should be the stop timeout but is %s", w.StopDeadline)
+		return
+	}
+}
+
+// A context aware worker that hangs until its context expires
+// is asked to StopNow after the stop timeout.
+func TestRunContextWorkerTimeout(t *testing.T) {
+	server := new(Server)
+	w := &contextWorkerSample{Hang: true}
+	server.AddContextWorker(w)
+	server.SetTimeouts(200*time.Millisecond, 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := server.Run(ctx); err != nil {
+		t.Errorf("Run() should return nil but returned [%v]", err)
+		return
+	}
+	if !w.StopCalled || !w.StopNowCalled {
+		t.Errorf("both Stop() and StopNow() should be called")
+		return
+	}
+
+	server.RemoveContextWorker(w)
+	if len(server.workers) != 0 {
+		t.Errorf("the context worker should be removed but there are %d workers", len(server.workers))
+		return
+	}
+}
+
+// The adapter blocks until the wrapped worker calls Done()
+// and fails with the context error if it never does.
+func TestAdaptWorker(t *testing.T) {
+	cw := AdaptWorker(new(sojuTest))
+	if err := cw.Stop(context.Background()); err != nil {
+		t.Errorf("Stop() should return nil but returned [%v]", err)
+		return
+	}
+
+	cw = AdaptWorker(new(firstTimeoutSojuTest))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := cw.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Stop() should return the context error but returned [%v]", err)
+		return
+	}
+}
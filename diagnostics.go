@@ -0,0 +1,179 @@
+package soju
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"time"
+)
+
+// State is the lifecycle state of the service or of a worker.
+type State int
+
+const (
+	// The component is registered but the server is not running.
+	StateIdle State = iota
+	// The component is being started, see Server.Start.
+	StateStarting
+	// The component is running.
+	StateRunning
+	// The component was asked to Stop and did not finish yet.
+	StateStopping
+	// The component was asked to StopNow and did not finish yet.
+	StateStoppingNow
+	// The component finished.
+	StateStopped
+	// The component exited and is waiting to be restarted, see Restart.
+	StateRestarting
+)
+
+func (st State) String() string {
+	switch st {
+	case StateIdle:
+		return "idle"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateStoppingNow:
+		return "stopping now"
+	case StateStopped:
+		return "stopped"
+	case StateRestarting:
+		return "restarting"
+	}
+	return fmt.Sprintf("State(%d)", int(st))
+}
+
+// The state of a component and when it was entered.
+type componentState struct {
+	state State
+	since time.Time
+}
+
+// Records the lifecycle state of a component.
+func (s *Server) setState(cw ContextWorker, state State) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.states == nil {
+		s.states = make(map[ContextWorker]componentState)
+	}
+	s.states[cw] = componentState{state: state, since: s.now()}
+
+	return
+
+}
+
+// Returns the lifecycle state of a component. Components without a recorded
+// state are running while the server runs and idle otherwise.
+func (s *Server) state(cw ContextWorker) componentState {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if cs, ok := s.states[cw]; ok {
+		return cs
+	}
+	if s.running {
+		return componentState{state: StateRunning}
+	}
+
+	return componentState{state: StateIdle}
+
+}
+
+// Sets where the diagnostics are written by ActionDump, os.Stderr by default.
+// Pass an *os.File (or a ReopenFile) to keep them in a file.
+func (s *Server) SetDumpOutput(w io.Writer) {
+	s.dumpOutput = w
+	return
+}
+
+// Dump writes the diagnostics of the server to w: the registered service and
+// workers with their lifecycle state, the open connections of the listeners
+// registered with AddListener (and those rejected by their limits), and the
+// stacks of all the goroutines. It is meant to find out what is stuck when the
+// server does not stop.
+func (s *Server) Dump(w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+	now := s.now()
+
+	fmt.Fprintf(bw, "soju diagnostics at %s\n", now.Format(time.RFC3339))
+
+	fmt.Fprintf(bw, "\ncomponents:\n")
+	if s.service != nil {
+		s.dumpComponent(bw, s.service, 0, now)
+	}
+	for _, worker := range s.copyWorkers() {
+		s.dumpComponent(bw, worker.ContextWorker, worker.tier, now)
+	}
+
+	s.Lock()
+	listeners := make([]namedListener, len(s.listeners))
+	copy(listeners, s.listeners)
+	s.Unlock()
+
+	fmt.Fprintf(bw, "\nlisteners:\n")
+	for _, l := range listeners {
+		conns := l.wl.ActiveConns()
+		fmt.Fprintf(bw, "%s %s: %d open connections, %d rejected\n", l.name, l.wl.Addr(), len(conns), l.wl.Rejected())
+		for _, wc := range conns {
+			fmt.Fprintf(bw, "  %s for %s\n", wc.RemoteAddr(), wc.Age())
+		}
+	}
+
+	fmt.Fprintf(bw, "\ngoroutines:\n")
+	if err := pprof.Lookup("goroutine").WriteTo(bw, 2); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+
+}
+
+// Writes one line describing a component.
+func (s *Server) dumpComponent(w io.Writer, cw ContextWorker, tier int, now time.Time) {
+
+	cs := s.state(cw)
+	fmt.Fprintf(w, "%s tier %d: %s", componentName(unwrap(cw)), tier, cs.state)
+	if !cs.since.IsZero() {
+		fmt.Fprintf(w, " for %s", now.Sub(cs.since))
+	}
+	fmt.Fprintln(w)
+
+	return
+
+}
+
+// ActionDump writes the diagnostics of the server (see Server.Dump) to the
+// dump output and keeps serving. It runs while stopping too, to find out what
+// is stuck before the StopNow phase.
+func ActionDump(s *Server, sig os.Signal) StopMode {
+
+	w := s.dumpOutput
+	if w == nil {
+		w = os.Stderr
+	}
+	s.Dump(w)
+
+	return NoStop
+
+}
+
+// Sets the dump output of the default static server.
+func SetDumpOutput(w io.Writer) {
+	defaultSojuServer.SetDumpOutput(w)
+	return
+}
+
+// Writes the diagnostics of the default static server.
+func Dump(w io.Writer) error {
+	return defaultSojuServer.Dump(w)
+}
@@ -0,0 +1,182 @@
+package soju
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A worker that does not stop until released.
+type stuckWorker struct {
+	release chan struct{}
+}
+
+func (sw *stuckWorker) Name() string {
+	return "stuck"
+}
+
+func (sw *stuckWorker) Stop(ctx context.Context) error {
+	<-sw.release
+	return nil
+}
+
+func (sw *stuckWorker) StopNow(ctx context.Context) error {
+	<-sw.release
+	return nil
+}
+
+// Dumps a running server with an open connection and a stuck worker
+func TestDump(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl := &WaitListener{Listener: l, WaitGroup: new(sync.WaitGroup)}
+	defer wl.Close()
+
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	worker := &stuckWorker{release: make(chan struct{})}
+	server.AddContextWorker(worker, Tier(1))
+	server.AddListener("http", wl)
+	server.SetTimeouts(50*time.Millisecond, 5*time.Second)
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := wl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+	waitRunning(server)
+
+	buf := new(bytes.Buffer)
+	if err := server.Dump(buf); err != nil {
+		t.Errorf("Dump() should return nil but returned [%v]", err)
+		return
+	}
+	dump := buf.String()
+	for _, expected := range []string{
+		"*soju.sojuTest tier 0: running\n",
+		"stuck tier 1: running\n",
+		"http " + l.Addr().String() + ": 1 open connections, 0 rejected\n",
+		"goroutine ",
+	} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("the dump should contain %q:\n%s", expected, dump)
+			return
+		}
+	}
+
+	// The worker gets stuck in StopNow.
+	cancel()
+	time.Sleep(200 * time.Millisecond)
+	buf.Reset()
+	server.Dump(buf)
+	if !strings.Contains(buf.String(), "stuck tier 1: stopping now for ") {
+		t.Errorf("the worker should be stopping now:\n%s", buf)
+	}
+
+	conn.Close()
+	close(worker.release)
+	<-done
+
+	buf.Reset()
+	server.Dump(buf)
+	if !strings.Contains(buf.String(), "stuck tier 1: stopped for ") || !strings.Contains(buf.String(), ": 0 open connections, ") {
+		t.Errorf("the worker should be stopped and the connection closed:\n%s", buf)
+	}
+}
+
+// Gets quit signal
+// Writes the diagnostics and keeps serving
+func TestActionDump(t *testing.T) {
+	server := new(Server)
+	notificable := new(sojuTest)
+	server.SetService(notificable)
+	buf := new(bytes.Buffer)
+	server.SetDumpOutput(buf)
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGQUIT
+		time.Sleep(time.Millisecond * 100)
+		server.c <- syscall.SIGTERM
+	}()
+	if result := server.Serve(1*time.Second, 500*time.Millisecond); result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+	if !strings.HasPrefix(buf.String(), "soju diagnostics at ") {
+		t.Errorf("unexpected dump:\n%s", buf)
+		return
+	}
+	if !notificable.StopCalled {
+		t.Errorf("Stop() method was not called")
+	}
+}
+
+// A buffer written and read from different goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
+
+// Gets terminate signal and a worker gets stuck in Stop
+// Gets quit signal and writes the diagnostics while stopping
+func TestActionDumpWhileStopping(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	worker := &stuckWorker{release: make(chan struct{})}
+	server.AddContextWorker(worker)
+	server.SetTimeouts(5*time.Second, 5*time.Second)
+	buf := new(lockedBuffer)
+	server.SetDumpOutput(buf)
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+		for server.state(worker).state != StateStopping {
+			time.Sleep(time.Millisecond)
+		}
+		server.c <- syscall.SIGQUIT
+		deadline := time.Now().Add(time.Second)
+		for !strings.Contains(buf.String(), "goroutine ") && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		close(worker.release)
+	}()
+	if result := server.Serve(5*time.Second, 5*time.Second); result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+	if !strings.Contains(buf.String(), "stuck tier 0: stopping for ") {
+		t.Errorf("the dump should show the stuck worker stopping:\n%s", buf)
+	}
+}
@@ -0,0 +1,42 @@
+package soju
+
+import (
+	"strings"
+)
+
+// Errors aggregates the errors returned by several workers (or the service)
+// during the same operation.
+type Errors []error
+
+func (e Errors) Error() string {
+
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+
+	return strings.Join(msgs, "; ")
+
+}
+
+// Returns nil if no error was collected, so that the result can be returned
+// as an error without getting a non-nil interface holding an empty slice.
+func (e Errors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ComponentError is the error returned by the Stop or StopNow method of the
+// service or a worker.
+type ComponentError struct {
+	// Name of the component, see Namer.
+	Name  string
+	Phase Phase
+	Err   error
+}
+
+func (e *ComponentError) Error() string {
+	return e.Name + ": " + e.Phase.String() + ": " + e.Err.Error()
+}
@@ -0,0 +1,108 @@
+package soju
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// EventType identifies a change in the server lifecycle.
+type EventType int
+
+const (
+	// The service and workers are started (or the server began to run, if
+	// it does not start them) and the server is waiting for signals.
+	EventReady EventType = iota
+	// A reconfiguration began.
+	EventReconfiguring
+	// A reconfiguration finished, Err holds its result.
+	EventReconfigured
+	// The shutdown began, Signal holds the stop signal (nil if it was the
+	// context).
+	EventStopping
+	// A tier began a shutdown phase, see Phase, Tier and Timeout.
+	EventPhase
+	// The shutdown finished, Report holds its report.
+	EventStopped
+	// An upgrade began.
+	EventUpgrading
+	// An upgrade finished, Err holds its result and Pid the new process.
+	EventUpgraded
+	// A supervised worker exited and is going to be restarted: Name holds
+	// the worker, Err why it exited and Timeout the time until the restart.
+	EventRestarting
+)
+
+func (et EventType) String() string {
+	switch et {
+	case EventReady:
+		return "ready"
+	case EventReconfiguring:
+		return "reconfiguring"
+	case EventReconfigured:
+		return "reconfigured"
+	case EventStopping:
+		return "stopping"
+	case EventPhase:
+		return "phase"
+	case EventStopped:
+		return "stopped"
+	case EventUpgrading:
+		return "upgrading"
+	case EventUpgraded:
+		return "upgraded"
+	case EventRestarting:
+		return "restarting"
+	}
+	return fmt.Sprintf("EventType(%d)", int(et))
+}
+
+// Event describes a change in the server lifecycle. Only the fields related to
+// its type are set.
+type Event struct {
+	Type   EventType
+	Signal os.Signal
+	Phase  Phase
+	Tier   int
+	// Time given to the tier to finish the phase.
+	Timeout time.Duration
+	Err     error
+	Report  *ShutdownReport
+	Pid     int
+	Name    string
+}
+
+// Adds a handler that receives every lifecycle event of the server. Handlers
+// are called synchronously, so they must not block.
+func (s *Server) AddEventHandler(handler func(Event)) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.eventHandlers = append(s.eventHandlers, handler)
+
+	return
+
+}
+
+// Sends the event to every handler.
+func (s *Server) emit(event Event) {
+
+	s.Lock()
+	handlers := make([]func(Event), len(s.eventHandlers))
+	copy(handlers, s.eventHandlers)
+	s.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+
+	return
+
+}
+
+// Adds an event handler to the default static server.
+func AddEventHandler(handler func(Event)) {
+	defaultSojuServer.AddEventHandler(handler)
+	return
+}
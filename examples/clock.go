@@ -15,7 +15,7 @@ type ClockService struct {
 	Called  bool
 	Started bool
 	Logger  *log.Logger
-	LogFile *os.File
+	LogFile *soju.ReopenFile
 
 	//Wrap a http.Server and add a sync.WaitGroup to
 	//track the http requests. Have a reference to the
@@ -68,8 +68,9 @@ func NewClockService(addr string) (service *ClockService) {
 //Wraps a net.Listener in a WaitListener and starts serving.
 func (cs *ClockService) Start() (err error) {
 
-	//Initialize a log file for the service
-	cs.LogFile, err = os.OpenFile("/tmp/clockwork.txt", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	//Initialize a log file for the service. It is reopened on SIGUSR1
+	//so that it can be rotated.
+	cs.LogFile, err = soju.OpenReopenFile("/tmp/clockwork.txt", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
 	if err != nil {
 		return
 	}
@@ -88,6 +89,9 @@ func (cs *ClockService) Start() (err error) {
 		WaitGroup: &cs.waitGroup,
 	}
 
+	//Let the server tell the listener which connections are idle, see Drain.
+	cs.Server.ConnState = cs.wl.ConnState
+
 	cs.Started = true
 
 	//This call blocks, so make it in a goroutine.
@@ -115,6 +119,9 @@ func (cs *ClockService) Stop(doneNotifier soju.DoneNotifier) (err error) {
 		return
 	}
 
+	//Close the keep-alive connections once they are idle.
+	cs.wl.Drain(time.Second)
+
 	cs.Logger.Println("Waiting for all pending requests to finish...")
 	//Block until all requests are done.
 	cs.waitGroup.Wait()
@@ -138,6 +145,15 @@ func (cs *ClockService) StopNow(doneNotifier soju.DoneNotifier) (err error) {
 
 	cs.Logger.Println("Aborted! Must stop all pending requests!")
 
+	if cs.Started {
+		//Do not accept new requests and abort the pending ones.
+		cs.wl.Close()
+		err = cs.wl.CloseConns()
+		if err != nil {
+			cs.Logger.Printf("Error closing the connections [%s]", err.Error())
+		}
+	}
+
 	cs.Logger.Println("Aborting the service...")
 	//Close the log file
 	err = cs.LogFile.Close()
@@ -158,3 +174,17 @@ func (cs *ClockService) Reconfigure() (err error) {
 	return
 
 }
+
+//Reopens the log file after it has been rotated.
+func (cs *ClockService) Reopen() (err error) {
+
+	err = cs.LogFile.Reopen()
+	if err != nil {
+		return
+	}
+
+	cs.Logger.Println("Log file reopened.")
+
+	return
+
+}
@@ -13,13 +13,9 @@ func main() {
 	signalable := examples.NewClockService("localhost:9111")
 
 	soju.SetService(signalable)
-	err := signalable.Start()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
 
-	exit := soju.Serve(4*time.Second, 2*time.Second)
+	// Soju starts the service, then waits for signals.
+	exit := soju.StartAndServe(2*time.Second, 4*time.Second, 2*time.Second)
 	fmt.Printf("Ending with exit code: %d", exit)
 
 	return
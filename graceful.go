@@ -0,0 +1,82 @@
+package soju
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// GracefulServer is the shape of servers like grpc.Server: Serve blocks
+// serving a listener, GracefulStop stops accepting connections and waits for
+// the pending requests, and Stop closes everything at once.
+type GracefulServer interface {
+	Serve(net.Listener) error
+	GracefulStop()
+	Stop()
+}
+
+// AdaptGracefulServer returns a Service serving the listener with the server.
+// The service Stop calls GracefulStop and its StopNow calls Stop, which also
+// makes a pending GracefulStop return. The service implements HealthChecker,
+// reporting the error that made Serve return, and Namer.
+func AdaptGracefulServer(server GracefulServer, listener net.Listener) Service {
+	return &gracefulServerAdapter{server: server, listener: listener}
+}
+
+type gracefulServerAdapter struct {
+	server   GracefulServer
+	listener net.Listener
+
+	mu  sync.Mutex
+	err error
+}
+
+func (gsa *gracefulServerAdapter) Name() string {
+	return fmt.Sprintf("%T %s", gsa.server, gsa.listener.Addr())
+}
+
+// Serves the listener in background.
+func (gsa *gracefulServerAdapter) Start() (err error) {
+
+	go func() {
+		if err := gsa.server.Serve(gsa.listener); err != nil {
+			gsa.mu.Lock()
+			gsa.err = err
+			gsa.mu.Unlock()
+		}
+	}()
+
+	return
+
+}
+
+func (gsa *gracefulServerAdapter) Healthy() error {
+
+	gsa.mu.Lock()
+	defer gsa.mu.Unlock()
+
+	return gsa.err
+
+}
+
+func (gsa *gracefulServerAdapter) Reconfigure() (err error) {
+	return
+}
+
+func (gsa *gracefulServerAdapter) Stop(dn DoneNotifier) (err error) {
+
+	gsa.server.GracefulStop()
+	dn.Done()
+
+	return
+
+}
+
+func (gsa *gracefulServerAdapter) StopNow(dn DoneNotifier) (err error) {
+
+	gsa.server.Stop()
+	dn.Done()
+
+	return
+
+}
@@ -0,0 +1,148 @@
+package soju
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A fake server with the gRPC server shape. GracefulStop waits for the
+// pending requests, which never finish unless released or stopped.
+type fakeGracefulServer struct {
+	mu                               sync.Mutex
+	served, gracefulStopped, stopped bool
+
+	pending  sync.WaitGroup
+	stopping chan struct{}
+	serveErr error
+}
+
+func newFakeGracefulServer(pending int) *fakeGracefulServer {
+	fgs := &fakeGracefulServer{stopping: make(chan struct{})}
+	fgs.pending.Add(pending)
+	return fgs
+}
+
+func (fgs *fakeGracefulServer) Serve(l net.Listener) error {
+	fgs.mu.Lock()
+	fgs.served = true
+	fgs.mu.Unlock()
+	if fgs.serveErr != nil {
+		return fgs.serveErr
+	}
+	<-fgs.stopping
+	return nil
+}
+
+func (fgs *fakeGracefulServer) GracefulStop() {
+	fgs.mu.Lock()
+	fgs.gracefulStopped = true
+	fgs.mu.Unlock()
+	fgs.pending.Wait()
+}
+
+func (fgs *fakeGracefulServer) Stop() {
+	fgs.mu.Lock()
+	defer fgs.mu.Unlock()
+	if !fgs.stopped {
+		fgs.stopped = true
+		close(fgs.stopping)
+	}
+}
+
+// Aborts the pending requests once the server is stopped.
+func (fgs *fakeGracefulServer) abortPending(n int) {
+	go func() {
+		<-fgs.stopping
+		for i := 0; i < n; i++ {
+			fgs.pending.Done()
+		}
+	}()
+}
+
+func testListener(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// Starts serving
+// Gets terminate signal and stops gracefully
+func TestGracefulServer(t *testing.T) {
+	l := testListener(t)
+	defer l.Close()
+	fgs := newFakeGracefulServer(0)
+
+	server := new(Server)
+	server.SetService(AdaptGracefulServer(fgs, l))
+	server.SetTimeouts(time.Second, time.Second)
+
+	go func() {
+		waitRunning(server)
+		time.Sleep(50 * time.Millisecond)
+		server.c <- syscall.SIGTERM
+	}()
+	report, err := server.run(context.Background(), true)
+	if err != nil {
+		t.Errorf("run() should return nil but returned [%v]", err)
+		return
+	}
+	if !fgs.served || !fgs.gracefulStopped || fgs.stopped {
+		t.Errorf("the server should be served and gracefully stopped %+v", fgs)
+		return
+	}
+	if name := "*soju.fakeGracefulServer " + l.Addr().String(); report.Components[0].Name != name {
+		t.Errorf("the service should be named %s but is %s", name, report.Components[0].Name)
+	}
+}
+
+// Gets terminate signal, a request does not finish in time
+// Stops the server now
+func TestGracefulServerStopNow(t *testing.T) {
+	l := testListener(t)
+	defer l.Close()
+	fgs := newFakeGracefulServer(1)
+	fgs.abortPending(1)
+
+	server := new(Server)
+	server.SetService(AdaptGracefulServer(fgs, l))
+	server.SetTimeouts(100*time.Millisecond, time.Second)
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+	}()
+	report, err := server.run(context.Background(), true)
+	if err != nil {
+		t.Errorf("run() should return nil but returned [%v]", err)
+		return
+	}
+	if !fgs.gracefulStopped || !fgs.stopped {
+		t.Errorf("the server should be gracefully stopped and then stopped %+v", fgs)
+		return
+	}
+	if report.Components[0].Phase != PhaseStopNow {
+		t.Errorf("the service should finish in StopNow but finished in %s", report.Components[0].Phase)
+	}
+}
+
+// Serve fails and the service is not healthy.
+func TestGracefulServerServeError(t *testing.T) {
+	l := testListener(t)
+	defer l.Close()
+	fgs := newFakeGracefulServer(0)
+	fgs.serveErr = errors.New("serve failed")
+
+	service := AdaptGracefulServer(fgs, l)
+	service.Start()
+	time.Sleep(50 * time.Millisecond)
+	if err := service.(HealthChecker).Healthy(); err != fgs.serveErr {
+		t.Errorf("Healthy() should return the Serve error but returned [%v]", err)
+	}
+}
@@ -0,0 +1,26 @@
+package soju
+
+// HealthChecker is an optional interface for the service and workers to report
+// whether they are working properly.
+type HealthChecker interface {
+	// Returns nil if healthy, or an error describing the problem.
+	Healthy() error
+}
+
+// Healthy checks the health of the service and of every registered worker
+// implementing HealthChecker. It returns nil if all of them are healthy, or an
+// Errors holding every problem.
+func (s *Server) Healthy() error {
+
+	var errs Errors
+	for _, component := range s.registered() {
+		if hc, ok := unwrap(component).(HealthChecker); ok {
+			if err := hc.Healthy(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs.orNil()
+
+}
@@ -0,0 +1,196 @@
+package soju
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPService is a ContextService serving an http.Server on one or more
+// addresses. Stop shuts the server down gracefully (see http.Server.Shutdown),
+// closing the listeners and the idle connections and waiting for the active
+// ones, and StopNow closes every connection (see http.Server.Close).
+type HTTPService struct {
+	Server *http.Server
+
+	// Addresses to listen on. If empty the server address is used, and if
+	// it is empty too ":http" or ":https".
+	Addrs []string
+
+	// Certificate and key files for TLS. They may be empty if the server
+	// TLSConfig provides the certificates, see http.Server.ServeTLS.
+	CertFile, KeyFile string
+
+	mu        sync.Mutex
+	listeners []*WaitListener
+	err       error
+	// Whether serving TLS, decided on Start since http.Server changes its
+	// TLSConfig while serving.
+	started bool
+	secure  bool
+}
+
+// NewHTTPService returns a service serving the server on the given addresses.
+func NewHTTPService(server *http.Server, addrs ...string) *HTTPService {
+	return &HTTPService{
+		Server: server,
+		Addrs:  addrs,
+	}
+}
+
+// Reports whether the service serves TLS: there are certificate files or
+// the server TLSConfig provides the certificates. The TLSConfig alone is not
+// enough, since http.Server sets one up for HTTP/2 when it begins to serve,
+// so once started the decision taken on Start is returned.
+func (hs *HTTPService) tls() bool {
+
+	hs.mu.Lock()
+	started, secure := hs.started, hs.secure
+	hs.mu.Unlock()
+	if started {
+		return secure
+	}
+
+	if hs.CertFile != "" || hs.KeyFile != "" {
+		return true
+	}
+
+	config := hs.Server.TLSConfig
+
+	return config != nil && (len(config.Certificates) > 0 || config.GetCertificate != nil || config.GetConfigForClient != nil)
+
+}
+
+// Returns the addresses to listen on.
+func (hs *HTTPService) addrs() []string {
+
+	if len(hs.Addrs) > 0 {
+		return hs.Addrs
+	}
+	if hs.Server.Addr != "" {
+		return []string{hs.Server.Addr}
+	}
+	if hs.tls() {
+		return []string{":https"}
+	}
+
+	return []string{":http"}
+
+}
+
+// Names the service after its addresses.
+func (hs *HTTPService) Name() string {
+
+	scheme := "http"
+	if hs.tls() {
+		scheme = "https"
+	}
+
+	return scheme + " " + strings.Join(hs.addrs(), ",")
+
+}
+
+// Listens on every address and starts serving. It fails if the certificate
+// files cannot be loaded or some address cannot be listened on, in which case
+// the listeners already open are closed.
+func (hs *HTTPService) Start(ctx context.Context) error {
+
+	secure := hs.tls()
+	if hs.CertFile != "" || hs.KeyFile != "" {
+		// ServeTLS loads them too, but only once serving in background.
+		if _, err := tls.LoadX509KeyPair(hs.CertFile, hs.KeyFile); err != nil {
+			return err
+		}
+	}
+	addrs := hs.addrs()
+
+	var listeners []*WaitListener
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, wl := range listeners {
+				wl.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, &WaitListener{Listener: l, WaitGroup: new(sync.WaitGroup)})
+	}
+
+	hs.mu.Lock()
+	hs.listeners = listeners
+	hs.err = nil
+	hs.started, hs.secure = true, secure
+	hs.mu.Unlock()
+
+	for _, wl := range listeners {
+		go hs.serve(wl, secure)
+	}
+
+	return nil
+
+}
+
+// Serves a listener until the server is shut down, recording any other error.
+func (hs *HTTPService) serve(wl *WaitListener, secure bool) {
+
+	var err error
+	if secure {
+		err = hs.Server.ServeTLS(wl, hs.CertFile, hs.KeyFile)
+	} else {
+		err = hs.Server.Serve(wl)
+	}
+
+	if err != http.ErrServerClosed {
+		hs.mu.Lock()
+		if hs.err == nil {
+			hs.err = err
+		}
+		hs.mu.Unlock()
+	}
+
+	return
+
+}
+
+// Returns the listeners, one per address, once started. They can be
+// registered in the server with AddListener to be passed on upgrades.
+func (hs *HTTPService) Listeners() []*WaitListener {
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	listeners := make([]*WaitListener, len(hs.listeners))
+	copy(listeners, hs.listeners)
+
+	return listeners
+
+}
+
+// Returns the error that made some listener stop serving, if any.
+func (hs *HTTPService) Healthy() error {
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	return hs.err
+
+}
+
+// Nothing to reconfigure, the server fields can be changed before starting.
+func (hs *HTTPService) Reconfigure() error {
+	return nil
+}
+
+// Shuts the server down gracefully. It returns the context error if the
+// active connections do not finish in time.
+func (hs *HTTPService) Stop(ctx context.Context) error {
+	return hs.Server.Shutdown(ctx)
+}
+
+// Closes the listeners and every connection.
+func (hs *HTTPService) StopNow(ctx context.Context) error {
+	return hs.Server.Close()
+}
@@ -0,0 +1,173 @@
+package soju
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Returns a self signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "soju"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// Returns the body of a GET request.
+func get(client *http.Client, url string) (string, error) {
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	return string(body), err
+}
+
+// Serves on two addresses
+// Stops gracefully while a request is running, which finishes
+func TestHTTPService(t *testing.T) {
+	started := make(chan struct{}, 1)
+	hs := NewHTTPService(&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	})}, "127.0.0.1:0", "127.0.0.1:0")
+
+	if hs.Name() != "http 127.0.0.1:0,127.0.0.1:0" {
+		t.Errorf("unexpected name %s", hs.Name())
+		return
+	}
+	if err := hs.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	listeners := hs.Listeners()
+	if len(listeners) != 2 {
+		t.Errorf("the service should have 2 listeners but has %d", len(listeners))
+		return
+	}
+	for _, wl := range listeners {
+		if body, err := get(http.DefaultClient, "http://"+wl.Addr().String()); err != nil || body != "ok" {
+			t.Errorf("unexpected answer %q [%v]", body, err)
+			return
+		}
+	}
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := get(http.DefaultClient, "http://"+listeners[0].Addr().String()+"/slow")
+		slow <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := hs.Stop(ctx); err != nil {
+		t.Errorf("Stop() should return nil but returned [%v]", err)
+		return
+	}
+	if err := <-slow; err != nil {
+		t.Errorf("the slow request should finish but got [%v]", err)
+		return
+	}
+	if err := hs.Healthy(); err != nil {
+		t.Errorf("Healthy() should return nil but returned [%v]", err)
+	}
+}
+
+// Serves TLS
+// Does not finish a request in time and is stopped now
+func TestHTTPServiceStopNow(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 1)
+	hs := NewHTTPService(&http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+		}),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+	}, "127.0.0.1:0")
+	if err := hs.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	stuck := make(chan error, 1)
+	go func() {
+		_, err := get(client, "https://"+hs.Listeners()[0].Addr().String())
+		stuck <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := hs.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Stop() should time out but returned [%v]", err)
+		return
+	}
+	if err := hs.StopNow(context.Background()); err != nil {
+		t.Errorf("StopNow() should return nil but returned [%v]", err)
+		return
+	}
+	if err := <-stuck; err == nil {
+		t.Errorf("the request should be aborted")
+	}
+}
+
+// A busy address fails to start and closes the other listeners.
+func TestHTTPServiceStartFailed(t *testing.T) {
+	busy := NewHTTPService(new(http.Server), "127.0.0.1:0")
+	if err := busy.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer busy.StopNow(context.Background())
+
+	hs := NewHTTPService(new(http.Server), "127.0.0.1:0", busy.Listeners()[0].Addr().String())
+	if err := hs.Start(context.Background()); err == nil {
+		t.Errorf("Start() should fail")
+		return
+	}
+	if len(hs.Listeners()) != 0 {
+		t.Errorf("the service shouldn't have listeners")
+	}
+}
+
+// Missing certificate files fail to start without listening.
+func TestHTTPServiceBadCertificate(t *testing.T) {
+	hs := NewHTTPService(new(http.Server), "127.0.0.1:0")
+	hs.CertFile, hs.KeyFile = "missing.crt", "missing.key"
+	if err := hs.Start(context.Background()); err == nil {
+		t.Errorf("Start() should fail")
+		return
+	}
+	if len(hs.Listeners()) != 0 {
+		t.Errorf("the service shouldn't have listeners")
+	}
+}
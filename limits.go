@@ -0,0 +1,211 @@
+package soju
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LimitPolicy tells what a WaitListener does with the connections over its
+// limits, see SetMaxConns and SetAcceptRate.
+type LimitPolicy int
+
+const (
+	// Accept waits until a new connection is within the limits, leaving the
+	// pending ones in the listen backlog.
+	LimitBlock LimitPolicy = iota
+	// Accept takes the connections over the limits and closes them at once,
+	// counting them as rejected.
+	LimitReject
+)
+
+// Limits of a WaitListener.
+type listenerLimits struct {
+	policy   LimitPolicy
+	maxConns int
+
+	// Token bucket for the accept rate.
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// Connections being accepted within the limits and not registered yet.
+	accepting int
+	// Closed and replaced when a connection is released or the listener
+	// closed.
+	changed chan struct{}
+
+	rejected int64
+}
+
+// Sets the maximum number of open connections, zero means no limit.
+func (wl *WaitListener) SetMaxConns(maxConns int) {
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	wl.limits.maxConns = maxConns
+	wl.notify()
+
+	return
+
+}
+
+// Sets the maximum number of connections accepted per second, allowing bursts
+// of up to burst connections. A zero rate means no limit.
+func (wl *WaitListener) SetAcceptRate(rate float64, burst int) {
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	if burst < 1 {
+		burst = 1
+	}
+	wl.limits.rate = rate
+	wl.limits.burst = float64(burst)
+	wl.limits.tokens = float64(burst)
+	wl.limits.last = time.Now()
+	wl.notify()
+
+	return
+
+}
+
+// Sets whether the connections over the limits wait (LimitBlock, the default)
+// or are rejected (LimitReject).
+func (wl *WaitListener) SetLimitPolicy(policy LimitPolicy) {
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	wl.limits.policy = policy
+	wl.notify()
+
+	return
+
+}
+
+// Returns the number of connections rejected for being over the limits.
+func (wl *WaitListener) Rejected() int64 {
+	return atomic.LoadInt64(&wl.limits.rejected)
+}
+
+// Wakes up the Accept calls waiting for the limits. Must be called with the
+// lock held.
+func (wl *WaitListener) notify() {
+
+	if wl.limits.changed != nil {
+		close(wl.limits.changed)
+		wl.limits.changed = nil
+	}
+
+	return
+
+}
+
+// Reserves a connection if there is room for it and, if there is an accept
+// rate, takes a token. Otherwise it returns how long to wait for a token, or
+// zero if waiting for a connection to be released. Must be called with the
+// lock held.
+func (wl *WaitListener) reserve(now time.Time) (bool, time.Duration) {
+
+	l := &wl.limits
+
+	if l.maxConns > 0 && len(wl.conns)+l.accepting >= l.maxConns {
+		return false, 0
+	}
+
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		if l.tokens < 1 {
+			return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		}
+		l.tokens--
+	}
+
+	l.accepting++
+
+	return true, 0
+
+}
+
+// Waits, if the policy is LimitBlock, until a connection can be reserved or
+// the listener is closed. It returns true if it reserved one.
+func (wl *WaitListener) waitLimits() bool {
+
+	for {
+
+		wl.mu.Lock()
+		if wl.limits.policy != LimitBlock || wl.closed {
+			wl.mu.Unlock()
+			return false
+		}
+		reserved, delay := wl.reserve(time.Now())
+		if reserved {
+			wl.mu.Unlock()
+			return true
+		}
+		if wl.limits.changed == nil {
+			wl.limits.changed = make(chan struct{})
+		}
+		changed := wl.limits.changed
+		wl.mu.Unlock()
+
+		if delay == 0 {
+			<-changed
+			continue
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+
+	}
+
+}
+
+// Decides whether an accepted connection is within the limits, reserving it
+// if it was not reserved while waiting. Connections over the limits are
+// counted as rejected.
+func (wl *WaitListener) admit(reserved bool) bool {
+
+	if reserved {
+		return true
+	}
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	if ok, _ := wl.reserve(time.Now()); ok {
+		return true
+	}
+
+	atomic.AddInt64(&wl.limits.rejected, 1)
+
+	return false
+
+}
+
+// Releases a reservation, registering the connection if it is not nil. Must
+// be called with the lock held.
+func (wl *WaitListener) unreserve(wc *WaitConn) {
+
+	wl.limits.accepting--
+	if wc != nil {
+		if wl.conns == nil {
+			wl.conns = make(map[*WaitConn]struct{})
+		}
+		wl.conns[wc] = struct{}{}
+	}
+	wl.notify()
+
+	return
+
+}
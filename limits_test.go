@@ -0,0 +1,148 @@
+package soju
+
+import (
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns a WaitListener on a local port.
+func testWaitListener(t *testing.T) *WaitListener {
+	return &WaitListener{Listener: testListener(t), WaitGroup: new(sync.WaitGroup)}
+}
+
+// Accepts in background.
+func acceptAsync(wl *WaitListener) <-chan error {
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := wl.Accept()
+		if err == nil {
+			defer conn.Close()
+		}
+		accepted <- err
+	}()
+	return accepted
+}
+
+// Dials the listener, failing the test on error.
+func dial(t *testing.T, wl *WaitListener) net.Conn {
+	conn, err := net.Dial("tcp", wl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// Accepts the maximum number of connections
+// Waits until one of them is closed to accept another one
+func TestMaxConnsBlock(t *testing.T) {
+	wl := testWaitListener(t)
+	defer wl.Close()
+	wl.SetMaxConns(1)
+
+	defer dial(t, wl).Close()
+	first, err := wl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dial(t, wl).Close()
+	accepted := acceptAsync(wl)
+	select {
+	case <-accepted:
+		t.Errorf("the second connection should wait")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	first.Close()
+	if err := <-accepted; err != nil {
+		t.Errorf("the second connection should be accepted but got [%v]", err)
+		return
+	}
+	if wl.Rejected() != 0 {
+		t.Errorf("no connection should be rejected but %d were", wl.Rejected())
+	}
+}
+
+// Accepts the maximum number of connections
+// Rejects the next one
+func TestMaxConnsReject(t *testing.T) {
+	wl := testWaitListener(t)
+	defer wl.Close()
+	wl.SetMaxConns(1)
+	wl.SetLimitPolicy(LimitReject)
+
+	defer dial(t, wl).Close()
+	first, err := wl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	accepted := acceptAsync(wl)
+	rejected := dial(t, wl)
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ioutil.ReadAll(rejected); err != nil {
+		t.Errorf("the rejected connection should be closed but got [%v]", err)
+		return
+	}
+	if wl.Rejected() != 1 {
+		t.Errorf("1 connection should be rejected but %d were", wl.Rejected())
+		return
+	}
+
+	// Accept goes on waiting for a connection within the limits.
+	wl.Close()
+	if err := <-accepted; err == nil {
+		t.Errorf("Accept() should fail once the listener is closed")
+	}
+}
+
+// Accepts one connection per 100ms after a burst of 2
+func TestAcceptRate(t *testing.T) {
+	wl := testWaitListener(t)
+	defer wl.Close()
+	wl.SetAcceptRate(10, 2)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		defer dial(t, wl).Close()
+		conn, err := wl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond || elapsed > time.Second {
+		t.Errorf("accepting should take about 200ms but took %s", elapsed)
+	}
+}
+
+// Closing the listener wakes up Accept waiting for the limits.
+func TestMaxConnsClose(t *testing.T) {
+	wl := testWaitListener(t)
+	wl.SetMaxConns(1)
+
+	defer dial(t, wl).Close()
+	first, err := wl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	accepted := acceptAsync(wl)
+	time.Sleep(50 * time.Millisecond)
+	wl.Close()
+	select {
+	case err := <-accepted:
+		if err == nil {
+			t.Errorf("Accept() should fail once the listener is closed")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Accept() should return once the listener is closed")
+	}
+}
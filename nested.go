@@ -0,0 +1,222 @@
+package soju
+
+import (
+	"sync"
+	"time"
+)
+
+// A Server is a Worker too, so that it can be registered in another server to
+// build supervision trees: every subsystem of a binary gets its own server,
+// with its own timeouts (see SetTimeouts, the ones of the parent apply until
+// they are set) and restart strategy, under a root server listening for the
+// signals.
+//
+// The parent starts it (see Start), runs it while serving, supervising its
+// workers without listening for signals, and stops it propagating Stop and
+// StopNow down the tree. Reconfigure, Reopen and Healthy are propagated too.
+// If one of its workers exhausts its restarts the nested server stops and
+// exits with the cause, so the parent restarts it according to the options it
+// was registered with (see Restart).
+
+// A shutdown of a nested server in progress.
+type childStop struct {
+	escalate chan struct{}
+	once     sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// Sets the name of the server in the reports of its parent.
+func (s *Server) SetName(name string) {
+	s.name = name
+	return
+}
+
+// Returns the name of the server, its type if none was set.
+func (s *Server) Name() string {
+
+	if s.name == "" {
+		return "*soju.Server"
+	}
+
+	return s.name
+
+}
+
+// A Run in progress, finished when done is closed.
+type rootRun struct {
+	done     chan struct{}
+	err      error
+	stopping bool
+}
+
+// Stops the service and workers gracefully, using the server timeouts, as if
+// the server received a SIGTERM. If the server is in Run, the stop is handed
+// to it. It returns like Run.
+func (s *Server) Stop(dn DoneNotifier) (err error) {
+
+	err = s.stop(false)
+	dn.Done()
+
+	return
+
+}
+
+// Stops the service and workers immediately. If the server is already
+// stopping gracefully, the shutdown jumps to the StopNow phase.
+func (s *Server) StopNow(dn DoneNotifier) (err error) {
+
+	err = s.stop(true)
+	dn.Done()
+
+	return
+
+}
+
+// Returns the stop and StopNow timeouts of the server. A nested server without
+// timeouts uses the ones of its parent, so that its components do not time
+// out at once.
+func (s *Server) timeouts() (stopTimeout, stopNowTimeout time.Duration) {
+
+	s.Lock()
+	stopTimeout, stopNowTimeout = s.stopTimeout, s.stopNowTimeout
+	parent := s.parent
+	s.Unlock()
+
+	if stopTimeout == 0 && stopNowTimeout == 0 && parent != nil {
+		stopTimeout, stopNowTimeout = parent.timeouts()
+	}
+
+	return
+
+}
+
+// Requests the stop of a server in Run and waits for Run to return, or stops
+// the server as a nested one.
+func (s *Server) stop(now bool) error {
+
+	s.Lock()
+	root, requests := s.root, s.stopRequests
+	s.Unlock()
+
+	if root == nil {
+		return s.stopChild(now)
+	}
+
+	r := stopRequest{mode: GracefulStop}
+	if now {
+		r.mode = ImmediateStop
+	}
+	select {
+	case requests <- r:
+	case <-root.done:
+	}
+	<-root.done
+
+	return root.err
+
+}
+
+// Takes the stop requests until stopped is closed, escalating on an immediate
+// one. The shutdown is already in progress, so the graceful ones are ignored.
+func (s *Server) watchStopRequests(requests <-chan stopRequest, escalate func(), stopped chan struct{}) {
+
+	for {
+		select {
+		case r := <-requests:
+			if r.mode == ImmediateStop {
+				escalate()
+			}
+		case <-stopped:
+			return
+		}
+	}
+
+}
+
+// Runs the shutdown of a nested server, or escalates and waits for the one in
+// progress. A server already stopped is not stopped again until started.
+func (s *Server) stopChild(now bool) error {
+
+	s.Lock()
+	if cs := s.stopping; cs != nil {
+		s.Unlock()
+		if now {
+			cs.once.Do(func() {
+				close(cs.escalate)
+			})
+		}
+		<-cs.done
+		return cs.err
+	}
+	if s.stopped {
+		s.Unlock()
+		return nil
+	}
+	cs := &childStop{
+		escalate: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.stopping = cs
+	s.Unlock()
+
+	s.endSupervision()
+	cs.err = s.shutdown(nil, now, cs.escalate).err()
+
+	s.Lock()
+	s.stopping = nil
+	s.stopped = true
+	s.running = false
+	s.Unlock()
+
+	close(cs.done)
+
+	return cs.err
+
+}
+
+// Serves the server as a worker of another one until it is stopped, or until
+// one of its workers exhausts its restarts, in which case it stops and returns
+// the cause.
+func (s *Server) serveChild() error {
+
+	s.Lock()
+	if s.running {
+		s.Unlock()
+		return ErrRunning
+	}
+	// Stopped by the parent before serving, see Start.
+	if s.stopped {
+		s.Unlock()
+		return nil
+	}
+	s.running = true
+	s.states = nil
+	s.cause = nil
+	s.stopRequests = make(chan stopRequest, 1)
+	requests := s.stopRequests
+	s.Unlock()
+
+	supervision := s.beginSupervision()
+
+	// Stopped by the parent while beginning, before the supervision could
+	// be ended.
+	s.Lock()
+	stopped := s.stopped || s.stopping != nil
+	s.Unlock()
+	if stopped {
+		s.endSupervision()
+		return nil
+	}
+
+	s.emit(Event{Type: EventReady})
+
+	select {
+	case <-requests:
+		return s.stopChild(false)
+	case <-supervision.Done():
+		// Stopped by the parent.
+		return nil
+	}
+
+}
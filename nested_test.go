@@ -0,0 +1,277 @@
+package soju
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A parent server with a nested server holding a service and a worker
+// Gets terminate signal and stops the whole tree
+func TestNestedServer(t *testing.T) {
+	child := new(Server)
+	child.SetName("child")
+	childService := new(sojuTest)
+	child.SetService(childService)
+	worker := newRunnerWorker(0, nil)
+	child.AddWorker(worker)
+	child.SetTimeouts(time.Second, time.Second)
+
+	parent := new(Server)
+	parent.SetService(new(sojuTest))
+	parent.AddWorker(child)
+
+	go func() {
+		waitRuns(worker, 1)
+		parent.c <- syscall.SIGTERM
+	}()
+	code, report := parent.ServeReport(time.Second, time.Second)
+	if code != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead:\n%s", code, report)
+		return
+	}
+	if !childService.StopCalled {
+		t.Errorf("Stop() method of the nested service was not called")
+		return
+	}
+	if report.Components[1].Name != "child" || report.Components[1].Phase != PhaseStop {
+		t.Errorf("unexpected report of the nested server %+v", report.Components[1])
+	}
+}
+
+// A nested server without timeouts holding a service and a worker
+// Stops within the timeouts of its parent
+func TestNestedServerParentTimeouts(t *testing.T) {
+	child := new(Server)
+	child.SetService(new(sojuTest))
+	worker := newRunnerWorker(0, nil)
+	child.AddWorker(worker)
+
+	parent := new(Server)
+	parent.AddWorker(child)
+
+	go func() {
+		waitRuns(worker, 1)
+		parent.c <- syscall.SIGTERM
+	}()
+	code, report := parent.ServeReport(time.Second, time.Second)
+	if code != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead:\n%s", code, report)
+		return
+	}
+	if stopTimeout, stopNowTimeout := child.timeouts(); stopTimeout != time.Second || stopNowTimeout != time.Second {
+		t.Errorf("the nested server should use the timeouts of its parent but uses %s and %s", stopTimeout, stopNowTimeout)
+	}
+}
+
+// A nested server with short timeouts and a stuck worker
+// Its parent gets the nested timeout as a Stop error
+func TestNestedServerTimeouts(t *testing.T) {
+	worker := &stuckWorker{release: make(chan struct{})}
+	defer close(worker.release)
+	child := new(Server)
+	child.AddContextWorker(worker)
+	child.SetTimeouts(50*time.Millisecond, 50*time.Millisecond)
+
+	parent := new(Server)
+	parent.AddWorker(child)
+
+	go func() {
+		waitRunning(parent)
+		parent.c <- syscall.SIGTERM
+	}()
+	start := time.Now()
+	code, report := parent.ServeReport(5*time.Second, 5*time.Second)
+	if code != ExitErrors {
+		t.Errorf("return code should be %d but is [%d] instead", ExitErrors, code)
+		return
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the nested timeouts should apply but the shutdown took %s", elapsed)
+		return
+	}
+	if report.Components[0].StopErr != ErrTimeout {
+		t.Errorf("the nested server should time out but returned [%v]", report.Components[0].StopErr)
+	}
+}
+
+// The parent does not wait for the nested server to Stop
+// The nested server jumps to its StopNow phase
+func TestNestedServerEscalation(t *testing.T) {
+	child := new(Server)
+	childService := new(firstTimeoutSojuTest)
+	child.SetService(childService)
+	child.SetTimeouts(5*time.Second, time.Second)
+
+	parent := new(Server)
+	parent.AddWorker(child)
+
+	go func() {
+		waitRunning(parent)
+		parent.c <- syscall.SIGTERM
+	}()
+	start := time.Now()
+	if code := parent.Serve(100*time.Millisecond, time.Second); code != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", code)
+		return
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the nested server should escalate but the shutdown took %s", elapsed)
+		return
+	}
+	if !childService.StopNowCalled {
+		t.Errorf("StopNow() method of the nested service was not called")
+	}
+}
+
+// A nested server whose worker exhausts its restarts stops with the cause
+// The parent restarts it until it exhausts its own restarts
+func TestNestedServerFailure(t *testing.T) {
+	worker := newRunnerWorker(-1, errors.New("failed"))
+	child := new(Server)
+	child.SetName("child")
+	child.AddWorker(worker, Restart(RestartAlways), RestartBackoff(time.Millisecond, time.Millisecond), MaxRestarts(1, time.Minute))
+	child.SetTimeouts(time.Second, time.Second)
+
+	parent := new(Server)
+	parent.AddWorker(child, Restart(RestartOnFailure), RestartBackoff(time.Millisecond, time.Millisecond), MaxRestarts(1, time.Minute))
+
+	code, report := parent.ServeReport(time.Second, time.Second)
+	if code != ExitWorkerFailed {
+		t.Errorf("return code should be %d but is [%d] instead", ExitWorkerFailed, code)
+		return
+	}
+	if report.Cause.Error() != "child: Run: runner: Run: failed" {
+		t.Errorf("unexpected cause [%v]", report.Cause)
+		return
+	}
+	// Two runs of the nested server with two runs of the worker each.
+	if worker.Runs() != 4 {
+		t.Errorf("the worker should run 4 times but ran %d", worker.Runs())
+	}
+}
+
+// Three workers, the second one fails once
+// Checks which ones are restarted by every strategy
+func TestRestartStrategies(t *testing.T) {
+	for _, test := range []struct {
+		strategy RestartStrategy
+		runs     [3]int
+	}{
+		{OneForOne, [3]int{1, 2, 1}},
+		{OneForAll, [3]int{2, 2, 2}},
+		{RestForOne, [3]int{1, 2, 2}},
+	} {
+		server := new(Server)
+		server.SetRestartStrategy(test.strategy)
+		workers := []*runnerWorker{
+			newRunnerWorker(0, nil),
+			newRunnerWorker(1, errors.New("failed")),
+			newRunnerWorker(0, nil),
+		}
+		for _, worker := range workers {
+			server.AddWorker(worker, Restart(RestartOnFailure), RestartBackoff(time.Millisecond, time.Millisecond))
+		}
+
+		go func() {
+			waitRuns(workers[1], 2)
+			time.Sleep(50 * time.Millisecond)
+			server.c <- syscall.SIGTERM
+		}()
+		if code := server.Serve(time.Second, time.Second); code != ExitOK {
+			t.Errorf("return code should be 0 but is [%d] instead", code)
+			return
+		}
+		for i, worker := range workers {
+			if worker.Runs() != test.runs[i] {
+				t.Errorf("with strategy %d worker %d should run %d times but ran %d", test.strategy, i, test.runs[i], worker.Runs())
+			}
+		}
+	}
+}
+
+// A root server in Run
+// Stop hands the stop to Run and waits for it, StopNow escalates it
+func TestRootServerStop(t *testing.T) {
+	server := new(Server)
+	service := &hangingService{calls: make(chan string, 2)}
+	server.SetService(service)
+	server.SetTimeouts(time.Hour, time.Second)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(context.Background())
+	}()
+	waitRunning(server)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Stop(newDoneNotifier())
+	}()
+	if call := <-service.calls; call != "Stop" {
+		t.Errorf("Stop() should be called but %s() was", call)
+		return
+	}
+	select {
+	case <-stopped:
+		t.Errorf("Stop() should wait for Run")
+		return
+	default:
+	}
+
+	go server.StopNow(newDoneNotifier())
+	if call := <-service.calls; call != "StopNow" {
+		t.Errorf("StopNow() should be called but %s() was", call)
+		return
+	}
+	if err := <-done; err != ErrTimeout {
+		t.Errorf("Run() should return ErrTimeout but returned [%v]", err)
+		return
+	}
+	if err := <-stopped; err != ErrTimeout {
+		t.Errorf("Stop() should return like Run but returned [%v]", err)
+		return
+	}
+
+	// Run again.
+	server.SetTimeouts(10*time.Millisecond, 10*time.Millisecond)
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+	}()
+	go func() {
+		<-service.calls
+		<-service.calls
+	}()
+	if err := server.Run(context.Background()); err != ErrTimeout {
+		t.Errorf("Run() should return ErrTimeout again but returned [%v]", err)
+	}
+}
+
+// A nested server is stopped before its parent serves it
+// It does not serve at all
+func TestNestedServerStoppedBeforeServing(t *testing.T) {
+	child := new(Server)
+	child.SetService(new(sojuTest))
+	child.SetTimeouts(time.Second, time.Second)
+
+	if err := child.Stop(newDoneNotifier()); err != nil {
+		t.Errorf("Stop() should return nil but returned [%v]", err)
+		return
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- child.serveChild()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serveChild() should return nil but returned [%v]", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("serveChild() shouldn't serve a stopped server")
+		child.StopNow(newDoneNotifier())
+	}
+}
@@ -1,8 +1,12 @@
 package soju
 
 import (
+	"crypto/tls"
 	"net"
+	"net/http"
+	"sort"
 	"sync"
+	"time"
 )
 
 //type WaitListener wraps a net.Listener and has a sync.WaitGroup to track
@@ -10,41 +14,339 @@ import (
 type WaitListener struct {
 	net.Listener
 	WaitGroup *sync.WaitGroup
+
+	// Registry of the connections not closed yet.
+	mu    sync.Mutex
+	conns map[*WaitConn]struct{}
+
+	// Drain mode, see Drain.
+	draining bool
+	idle     time.Duration
+	closed   bool
+
+	// Connection limits, see SetMaxConns and SetAcceptRate.
+	limits listenerLimits
 }
 
 func (wl *WaitListener) Accept() (conn net.Conn, err error) {
 
 	//Call Add before the event to be waited for (the connection)
 	wl.WaitGroup.Add(1)
-	//Call the underlying listener's Accept() method.
-	c, err := wl.Listener.Accept()
-	if err != nil {
-		wl.WaitGroup.Done()
-		return
+
+	var c net.Conn
+	for {
+
+		//Wait until the connection is within the limits, if blocking.
+		reserved := wl.waitLimits()
+
+		//Call the underlying listener's Accept() method.
+		c, err = wl.Listener.Accept()
+		if err != nil {
+			if reserved {
+				wl.mu.Lock()
+				wl.unreserve(nil)
+				wl.mu.Unlock()
+			}
+			wl.WaitGroup.Done()
+			return
+		}
+
+		if wl.admit(reserved) {
+			break
+		}
+		//Reject the connections over the limits.
+		c.Close()
+
 	}
 
 	//Wrap the connection in a WaitConn.
-	conn = &WaitConn{
+	wc := &WaitConn{
 		Conn: c,
 		//Use the WaitListener's WaitGroup
 		WaitGroup: wl.WaitGroup,
+		listener:  wl,
+		accepted:  time.Now(),
 	}
 
+	wl.mu.Lock()
+	wl.unreserve(wc)
+	wl.mu.Unlock()
+
+	conn = wc
+
 	return
 
 }
 
+// Closes the listener. The open connections are not closed.
+func (wl *WaitListener) Close() error {
+
+	wl.mu.Lock()
+	wl.closed = true
+	wl.notify()
+	wl.mu.Unlock()
+
+	return wl.Listener.Close()
+
+}
+
+// Drain puts the listener in drain mode, meant for graceful stops: the
+// connections idle for at least the given threshold are closed, both the
+// current ones and those becoming idle later, while the active ones are let
+// finish. The reaping goes on until the listener is closed and every
+// connection is gone. Calling it again changes the threshold.
+//
+// For an http.Server, set ConnState as its hook so that the connections are
+// idle when the server says so. Otherwise connections are considered idle when
+// they are waiting to read and every data read has been answered (written to
+// after it), which is only safe for strict request/response protocols: a
+// response streamed by a handler that pauses for longer than the threshold
+// looks idle, since net/http keeps reading in background while serving.
+func (wl *WaitListener) Drain(idle time.Duration) {
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	wl.idle = idle
+	if wl.draining {
+		return
+	}
+	wl.draining = true
+
+	interval := idle / 4
+	if interval < minReapInterval {
+		interval = minReapInterval
+	}
+	go wl.reap(interval)
+
+	return
+
+}
+
+// ConnState tracks the state of the connections of the listener served by an
+// http.Server, to be set as its ConnState hook (or called from it). Drain then
+// only closes the connections waiting for a new request.
+func (wl *WaitListener) ConnState(c net.Conn, state http.ConnState) {
+
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	wc, ok := c.(*WaitConn)
+	if !ok {
+		return
+	}
+
+	wc.activity.Lock()
+	defer wc.activity.Unlock()
+
+	idle := state == http.StateNew || state == http.StateIdle
+	if idle && (!wc.tracked || !wc.idle) {
+		wc.idleAt = time.Now()
+	}
+	wc.tracked = true
+	wc.idle = idle
+
+	return
+
+}
+
+// Shortest interval between idle connection checks.
+const minReapInterval = 10 * time.Millisecond
+
+// Closes the idle connections every interval until the listener is closed
+// and has no connections.
+func (wl *WaitListener) reap(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+
+		wl.mu.Lock()
+		idle, done := wl.idle, wl.closed && len(wl.conns) == 0
+		wl.mu.Unlock()
+
+		if done {
+			return
+		}
+
+		now := time.Now()
+		for _, wc := range wl.ActiveConns() {
+			if wc.idleSince(now) >= idle {
+				wc.Close()
+			}
+		}
+
+		<-ticker.C
+
+	}
+
+}
+
+// Returns the number of connections accepted by the listener and not closed
+// yet.
+func (wl *WaitListener) OpenConns() int {
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	return len(wl.conns)
+
+}
+
+// ActiveConns returns the connections accepted by the listener and not closed
+// yet, the oldest first.
+func (wl *WaitListener) ActiveConns() []*WaitConn {
+
+	wl.mu.Lock()
+	conns := make([]*WaitConn, 0, len(wl.conns))
+	for wc := range wl.conns {
+		conns = append(conns, wc)
+	}
+	wl.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].accepted.Before(conns[j].accepted)
+	})
+
+	return conns
+
+}
+
+// CloseConns forcibly closes every open connection, to be called from StopNow
+// once the listener is closed so that the pending requests are aborted instead
+// of waited for. It returns an Errors holding the errors closing them, if any.
+func (wl *WaitListener) CloseConns() error {
+
+	var errs Errors
+	for _, wc := range wl.ActiveConns() {
+		if err := wc.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs.orNil()
+
+}
+
 //type WaitConn wraps a net.Conn and decrements the received WaitGroup
 //when the connection is closed.
 type WaitConn struct {
 	net.Conn
 	WaitGroup *sync.WaitGroup
 	once      sync.Once
+
+	// Listener that accepted the connection, if any, and when.
+	listener *WaitListener
+	accepted time.Time
+
+	// Activity, to find out whether the connection is idle.
+	activity  sync.Mutex
+	reading   bool
+	lastRead  time.Time
+	lastWrite time.Time
+
+	// State reported by an http.Server, see ConnState.
+	tracked bool
+	idle    bool
+	idleAt  time.Time
+}
+
+func (wc *WaitConn) Read(b []byte) (n int, err error) {
+
+	wc.activity.Lock()
+	wc.reading = true
+	wc.activity.Unlock()
+
+	n, err = wc.Conn.Read(b)
+
+	wc.activity.Lock()
+	wc.reading = false
+	if n > 0 {
+		wc.lastRead = time.Now()
+	}
+	wc.activity.Unlock()
+
+	return
+
+}
+
+func (wc *WaitConn) Write(b []byte) (n int, err error) {
+
+	n, err = wc.Conn.Write(b)
+
+	if n > 0 {
+		wc.activity.Lock()
+		wc.lastWrite = time.Now()
+		wc.activity.Unlock()
+	}
+
+	return
+
+}
+
+// Returns for how long the connection has been idle, a negative duration if it
+// is active: not waiting for a request according to its http.Server (see
+// ConnState) or, if untracked, not waiting to read or with data read and not
+// answered.
+func (wc *WaitConn) idleSince(now time.Time) time.Duration {
+
+	wc.activity.Lock()
+	defer wc.activity.Unlock()
+
+	if wc.tracked {
+		if !wc.idle {
+			return -1
+		}
+		return now.Sub(wc.idleAt)
+	}
+
+	if !wc.reading || wc.lastWrite.Before(wc.lastRead) {
+		return -1
+	}
+
+	last := wc.accepted
+	if wc.lastRead.After(last) {
+		last = wc.lastRead
+	}
+	if wc.lastWrite.After(last) {
+		last = wc.lastWrite
+	}
+
+	return now.Sub(last)
+
 }
 
 func (wc *WaitConn) Close() error {
 	//Is possible to call Close() more than once?
-	defer wc.once.Do(wc.WaitGroup.Done)
+	defer wc.once.Do(wc.done)
 	//Close the underlying connection.
 	return wc.Conn.Close()
 }
+
+// Returns how long ago the connection was accepted, zero if it was not
+// accepted by a WaitListener.
+func (wc *WaitConn) Age() time.Duration {
+
+	if wc.accepted.IsZero() {
+		return 0
+	}
+
+	return time.Since(wc.accepted)
+
+}
+
+// Releases the connection from its WaitGroup and listener.
+func (wc *WaitConn) done() {
+
+	if wc.listener != nil {
+		wc.listener.mu.Lock()
+		delete(wc.listener.conns, wc)
+		wc.listener.notify()
+		wc.listener.mu.Unlock()
+	}
+	wc.WaitGroup.Done()
+
+	return
+
+}
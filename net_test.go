@@ -0,0 +1,198 @@
+package soju
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Accepts two connections
+// Lists them, oldest first, and closes them forcibly
+func TestWaitListenerConns(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl := &WaitListener{Listener: l, WaitGroup: new(sync.WaitGroup)}
+	defer wl.Close()
+
+	var clients []net.Conn
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+		if _, err := wl.Accept(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conns := wl.ActiveConns()
+	if len(conns) != 2 || wl.OpenConns() != 2 {
+		t.Errorf("the listener should have 2 connections but has %d", len(conns))
+		return
+	}
+	for i, wc := range conns {
+		if wc.RemoteAddr().String() != clients[i].LocalAddr().String() {
+			t.Errorf("connection %d comes from %s instead of %s", i, wc.RemoteAddr(), clients[i].LocalAddr())
+			return
+		}
+	}
+	if conns[0].Age() <= conns[1].Age() {
+		t.Errorf("the first connection should be older: %s, %s", conns[0].Age(), conns[1].Age())
+		return
+	}
+
+	if err := wl.CloseConns(); err != nil {
+		t.Errorf("CloseConns() should return nil but returned [%v]", err)
+		return
+	}
+	if wl.OpenConns() != 0 {
+		t.Errorf("the listener should have no connections but has %d", wl.OpenConns())
+		return
+	}
+	// Every connection is released.
+	wl.WaitGroup.Wait()
+
+	clients[0].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ioutil.ReadAll(clients[0]); err != nil {
+		t.Errorf("the client should read EOF but got [%v]", err)
+	}
+}
+
+// Connections not accepted by a WaitListener have no age.
+func TestWaitConnAge(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	wc := &WaitConn{Conn: server, WaitGroup: wg}
+	if wc.Age() != 0 {
+		t.Errorf("the age should be 0 but is %s", wc.Age())
+	}
+	wc.Close()
+	wg.Wait()
+}
+
+// Serves an idle keep-alive connection and a slow request
+// Drains the listener: the idle connection is closed and the slow request
+// finishes
+func TestWaitListenerDrain(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl := &WaitListener{Listener: l, WaitGroup: new(sync.WaitGroup)}
+
+	started := make(chan struct{}, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	})}
+	go server.Serve(wl)
+
+	url := "http://" + l.Addr().String()
+	// Each client keeps its own connection alive.
+	idleClient := &http.Client{Transport: new(http.Transport)}
+	res, err := idleClient.Get(url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	slow := make(chan error, 1)
+	go func() {
+		res, err := (&http.Client{Transport: new(http.Transport)}).Get(url + "/slow")
+		if err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err == nil && string(body) != "ok" {
+				err = fmt.Errorf("unexpected body %q", body)
+			}
+		}
+		slow <- err
+	}()
+	<-started
+
+	if wl.OpenConns() != 2 {
+		t.Errorf("the listener should have 2 connections but has %d", wl.OpenConns())
+		return
+	}
+
+	wl.Close()
+	wl.Drain(50 * time.Millisecond)
+
+	time.Sleep(150 * time.Millisecond)
+	if wl.OpenConns() != 1 {
+		t.Errorf("the idle connection should be closed, %d connections left", wl.OpenConns())
+		return
+	}
+
+	if err := <-slow; err != nil {
+		t.Errorf("the slow request should finish but got [%v]", err)
+		return
+	}
+	// The slow connection is closed once idle.
+	wl.WaitGroup.Wait()
+}
+
+// A handler flushes part of the response and pauses longer than the drain
+// threshold
+// With ConnState the connection is not closed until the response is done
+func TestWaitListenerDrainStreaming(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl := &WaitListener{Listener: l, WaitGroup: new(sync.WaitGroup)}
+
+	started := make(chan struct{}, 1)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("first "))
+			w.(http.Flusher).Flush()
+			started <- struct{}{}
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("second"))
+		}),
+		ConnState: wl.ConnState,
+	}
+	go server.Serve(wl)
+
+	streamed := make(chan error, 1)
+	go func() {
+		res, err := (&http.Client{Transport: new(http.Transport)}).Get("http://" + l.Addr().String())
+		if err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err == nil && string(body) != "first second" {
+				err = fmt.Errorf("unexpected body %q", body)
+			}
+		}
+		streamed <- err
+	}()
+	<-started
+
+	wl.Close()
+	wl.Drain(50 * time.Millisecond)
+
+	if err := <-streamed; err != nil {
+		t.Errorf("the streamed response should finish but got [%v]", err)
+		return
+	}
+	// The connection is closed once idle.
+	wl.WaitGroup.Wait()
+}
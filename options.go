@@ -0,0 +1,72 @@
+package soju
+
+import (
+	"sort"
+	"time"
+)
+
+// WorkerOption configures how the server manages a worker.
+type WorkerOption func(*workerOptions)
+
+type workerOptions struct {
+	tier int
+
+	// Supervision, see Restart.
+	restart     RestartPolicy
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxRestarts int
+	window      time.Duration
+}
+
+// Tier sets the shutdown tier of a worker. Tiers are stopped one after the
+// other in ascending order, each of them getting its own slice of the stop
+// timeout, and the StopNow escalation follows the same order. The service is
+// always in tier 0, which is also the default for workers, so lower tiers stop
+// before the service and higher ones after it.
+func Tier(tier int) WorkerOption {
+	return func(o *workerOptions) {
+		o.tier = tier
+	}
+}
+
+// A worker registered in a server.
+type registeredWorker struct {
+	ContextWorker
+	workerOptions
+}
+
+func newRegisteredWorker(worker ContextWorker, opts []WorkerOption) *registeredWorker {
+
+	rw := &registeredWorker{
+		ContextWorker: worker,
+		workerOptions: workerOptions{
+			backoff:    DefaultRestartBackoff,
+			maxBackoff: DefaultMaxRestartBackoff,
+		},
+	}
+	for _, opt := range opts {
+		opt(&rw.workerOptions)
+	}
+
+	return rw
+
+}
+
+// Groups the components by tier, in ascending order.
+func groupTiers(components []*component) (tiers [][]*component) {
+
+	sort.SliceStable(components, func(i, j int) bool {
+		return components[i].report.Tier < components[j].report.Tier
+	})
+
+	for i, c := range components {
+		if i == 0 || c.report.Tier != components[i-1].report.Tier {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], c)
+	}
+
+	return
+
+}
@@ -0,0 +1,126 @@
+package soju
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the order in which the components are stopped.
+type stopRecorder struct {
+	sync.Mutex
+	calls []string
+}
+
+func (sr *stopRecorder) record(call string) {
+	sr.Lock()
+	defer sr.Unlock()
+	sr.calls = append(sr.calls, call)
+}
+
+type tierWorkerSample struct {
+	name     string
+	recorder *stopRecorder
+	// If true, Stop blocks until the context expires.
+	Hang bool
+}
+
+func (tws *tierWorkerSample) Stop(ctx context.Context) (err error) {
+	tws.recorder.record(tws.name + ".Stop")
+	if tws.Hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return
+}
+func (tws *tierWorkerSample) StopNow(ctx context.Context) (err error) {
+	tws.recorder.record(tws.name + ".StopNow")
+	return
+}
+func (tws *tierWorkerSample) Reconfigure() (err error) {
+	return
+}
+func (tws *tierWorkerSample) Start(ctx context.Context) (err error) {
+	return
+}
+
+func checkCalls(t *testing.T, recorder *stopRecorder, expected ...string) {
+	if len(recorder.calls) != len(expected) {
+		t.Errorf("expected calls %v but got %v", expected, recorder.calls)
+		return
+	}
+	for i := range expected {
+		if recorder.calls[i] != expected[i] {
+			t.Errorf("expected calls %v but got %v", expected, recorder.calls)
+			return
+		}
+	}
+}
+
+// Registers workers in several tiers
+// Cancels the context
+// Stops the tiers in order
+func TestTiersStopInOrder(t *testing.T) {
+	recorder := new(stopRecorder)
+	server := new(Server)
+	server.SetContextService(&tierWorkerSample{name: "service", recorder: recorder})
+	server.AddContextWorker(&tierWorkerSample{name: "db", recorder: recorder}, Tier(2))
+	server.AddContextWorker(&tierWorkerSample{name: "queue", recorder: recorder}, Tier(1))
+	server.AddContextWorker(&tierWorkerSample{name: "frontend", recorder: recorder}, Tier(-1))
+	server.SetTimeouts(1*time.Second, 500*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := server.RunReport(ctx)
+	if err != nil {
+		t.Errorf("RunReport() should return nil but returned [%v]", err)
+		return
+	}
+	checkCalls(t, recorder, "frontend.Stop", "service.Stop", "queue.Stop", "db.Stop")
+	if report.Components[1].Tier != 2 {
+		t.Errorf("the db worker should report tier 2 but reports %d", report.Components[1].Tier)
+	}
+}
+
+// Registers a worker that hangs in the first tier
+// The tier gets only its slice of the stop timeout
+// StopNow escalation follows the tiers order
+func TestTiersEscalation(t *testing.T) {
+	recorder := new(stopRecorder)
+	server := new(Server)
+	server.SetContextService(&tierWorkerSample{name: "service", recorder: recorder})
+	server.AddContextWorker(&tierWorkerSample{name: "frontend", recorder: recorder, Hang: true}, Tier(-1))
+	server.AddContextWorker(&tierWorkerSample{name: "db", recorder: recorder}, Tier(1))
+	server.SetTimeouts(600*time.Millisecond, 300*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := server.RunReport(ctx)
+	if err != nil {
+		t.Errorf("RunReport() should return nil but returned [%v]", err)
+		return
+	}
+	checkCalls(t, recorder, "frontend.Stop", "frontend.StopNow", "service.StopNow", "db.StopNow")
+	if report.Duration > 400*time.Millisecond {
+		t.Errorf("the hanging tier should get a third of the stop timeout but the shutdown took %s", report.Duration)
+	}
+}
+
+// Registers a worker that hangs after the service
+// Only the tiers that did not finish are stopped now
+func TestTiersEscalationSkipsStopped(t *testing.T) {
+	recorder := new(stopRecorder)
+	server := new(Server)
+	server.SetContextService(&tierWorkerSample{name: "service", recorder: recorder})
+	server.AddContextWorker(&tierWorkerSample{name: "db", recorder: recorder, Hang: true}, Tier(1))
+	server.SetTimeouts(200*time.Millisecond, 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := server.Run(ctx); err != nil {
+		t.Errorf("Run() should return nil but returned [%v]", err)
+		return
+	}
+	checkCalls(t, recorder, "service.Stop", "db.Stop", "db.StopNow")
+}
@@ -0,0 +1,166 @@
+package soju
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolStopped is returned by WorkerPool.Submit once the pool is stopping.
+var ErrPoolStopped = errors.New("soju: worker pool stopped")
+
+// Job is a unit of work run by a WorkerPool. The context is cancelled when the
+// pool is asked to StopNow.
+type Job func(context.Context) error
+
+// WorkerPool is a Worker running jobs in a fixed number of goroutines. Stop
+// refuses new jobs and waits until every queued and running job has finished.
+// StopNow cancels the context of the running jobs and hands the queued ones
+// to the requeue handler instead of running them.
+type WorkerPool struct {
+	jobs chan Job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Guards stopping the pool while jobs are being submitted.
+	mu         sync.Mutex
+	stopped    bool
+	stopping   chan struct{}
+	submitting sync.WaitGroup
+
+	requeueHandler func(Job)
+	errorHandler   func(error)
+}
+
+// NewWorkerPool returns a pool running jobs in the given number of goroutines,
+// which are started at once, with a queue of the given size.
+func NewWorkerPool(workers, queue int) *WorkerPool {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wp := &WorkerPool{
+		jobs:     make(chan Job, queue),
+		ctx:      ctx,
+		cancel:   cancel,
+		stopping: make(chan struct{}),
+	}
+
+	wp.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go wp.work()
+	}
+
+	return wp
+
+}
+
+// Sets the handler receiving the jobs not run because of a StopNow, so that
+// they can be requeued elsewhere. Without one they are dropped.
+func (wp *WorkerPool) SetRequeueHandler(handler func(Job)) {
+	wp.requeueHandler = handler
+	return
+}
+
+// Sets the handler receiving the errors returned by the jobs. It is called
+// from the pool goroutines.
+func (wp *WorkerPool) SetErrorHandler(handler func(error)) {
+	wp.errorHandler = handler
+	return
+}
+
+// Submit queues a job, blocking while the queue is full. It returns
+// ErrPoolStopped if the pool is stopping.
+func (wp *WorkerPool) Submit(job Job) error {
+
+	wp.mu.Lock()
+	if wp.stopped {
+		wp.mu.Unlock()
+		return ErrPoolStopped
+	}
+	wp.submitting.Add(1)
+	wp.mu.Unlock()
+
+	defer wp.submitting.Done()
+
+	select {
+	case wp.jobs <- job:
+		return nil
+	case <-wp.stopping:
+		return ErrPoolStopped
+	}
+
+}
+
+// Returns the number of queued jobs.
+func (wp *WorkerPool) Pending() int {
+	return len(wp.jobs)
+}
+
+// Runs the queued jobs until the queue is closed.
+func (wp *WorkerPool) work() {
+
+	defer wp.wg.Done()
+
+	for job := range wp.jobs {
+
+		// Aborted: hand over the job instead of running it.
+		if wp.ctx.Err() != nil {
+			if wp.requeueHandler != nil {
+				wp.requeueHandler(job)
+			}
+			continue
+		}
+
+		if err := job(wp.ctx); err != nil && wp.errorHandler != nil {
+			wp.errorHandler(err)
+		}
+
+	}
+
+	return
+
+}
+
+// Refuses new jobs and closes the queue once the jobs being submitted are in.
+func (wp *WorkerPool) close() {
+
+	wp.mu.Lock()
+	if wp.stopped {
+		wp.mu.Unlock()
+		return
+	}
+	wp.stopped = true
+	close(wp.stopping)
+	wp.mu.Unlock()
+
+	wp.submitting.Wait()
+	close(wp.jobs)
+
+	return
+
+}
+
+// Refuses new jobs and waits until the queued and running ones have finished.
+func (wp *WorkerPool) Stop(dn DoneNotifier) (err error) {
+
+	wp.close()
+	wp.wg.Wait()
+	dn.Done()
+
+	return
+
+}
+
+// Cancels the running jobs, requeues the queued ones and waits until the pool
+// goroutines have finished.
+func (wp *WorkerPool) StopNow(dn DoneNotifier) (err error) {
+
+	wp.cancel()
+	wp.close()
+	wp.wg.Wait()
+	dn.Done()
+
+	return
+
+}
@@ -0,0 +1,128 @@
+package soju
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Queues some jobs
+// Gets terminate signal, refuses new jobs and runs the queued ones
+func TestWorkerPoolStop(t *testing.T) {
+	pool := NewWorkerPool(2, 10)
+	var done int64
+	for i := 0; i < 5; i++ {
+		pool.Submit(func(ctx context.Context) error {
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt64(&done, 1)
+			return nil
+		})
+	}
+
+	server := new(Server)
+	server.AddWorker(pool)
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+	}()
+	if result := server.Serve(time.Second, time.Second); result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+	if atomic.LoadInt64(&done) != 5 {
+		t.Errorf("5 jobs should be done but %d are", done)
+		return
+	}
+	if err := pool.Submit(func(context.Context) error { return nil }); err != ErrPoolStopped {
+		t.Errorf("Submit() should return ErrPoolStopped but returned [%v]", err)
+	}
+}
+
+// A job runs until cancelled and others are queued
+// Gets abort signal, cancels the running job and requeues the queued ones
+func TestWorkerPoolStopNow(t *testing.T) {
+	pool := NewWorkerPool(1, 10)
+
+	var mu sync.Mutex
+	var requeued []Job
+	var errs []error
+	pool.SetRequeueHandler(func(job Job) {
+		mu.Lock()
+		requeued = append(requeued, job)
+		mu.Unlock()
+	})
+	pool.SetErrorHandler(func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+
+	running := make(chan struct{})
+	pool.Submit(func(ctx context.Context) error {
+		close(running)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	for i := 0; i < 3; i++ {
+		pool.Submit(func(context.Context) error {
+			t.Errorf("the queued jobs shouldn't run")
+			return nil
+		})
+	}
+	<-running
+	if pool.Pending() != 3 {
+		t.Errorf("3 jobs should be queued but %d are", pool.Pending())
+		return
+	}
+
+	server := new(Server)
+	server.AddWorker(pool)
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGABRT
+	}()
+	if result := server.Serve(time.Second, time.Second); result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requeued) != 3 {
+		t.Errorf("3 jobs should be requeued but %d were", len(requeued))
+		return
+	}
+	if len(errs) != 1 || errs[0] != context.Canceled {
+		t.Errorf("the running job should be cancelled but returned %v", errs)
+	}
+}
+
+// Submit blocked on a full queue returns once the pool stops.
+func TestWorkerPoolSubmitBlocked(t *testing.T) {
+	pool := NewWorkerPool(1, 0)
+	release := make(chan struct{})
+	pool.Submit(func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- pool.Submit(func(context.Context) error { return nil })
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		pool.Stop(newDoneNotifier())
+		close(stopped)
+	}()
+	if err := <-submitted; err != ErrPoolStopped {
+		t.Errorf("Submit() should return ErrPoolStopped but returned [%v]", err)
+	}
+	close(release)
+	<-stopped
+}
@@ -0,0 +1,88 @@
+package soju
+
+import (
+	"os"
+	"sync"
+)
+
+// ReopenFile is an io.Writer on a file that can be reopened, so that the file
+// can be rotated (e.g. by logrotate) while the service is writing to it.
+type ReopenFile struct {
+	sync.Mutex
+
+	path string
+	flag int
+	perm os.FileMode
+	file *os.File
+}
+
+// OpenReopenFile opens the file like os.OpenFile. Reopening the file opens it
+// again with the same flags.
+func OpenReopenFile(path string, flag int, perm os.FileMode) (*ReopenFile, error) {
+
+	rf := &ReopenFile{
+		path: path,
+		flag: flag,
+		perm: perm,
+	}
+
+	if err := rf.Reopen(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+
+}
+
+// Writes to the current file.
+func (rf *ReopenFile) Write(p []byte) (int, error) {
+
+	rf.Lock()
+	defer rf.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	return rf.file.Write(p)
+
+}
+
+// Reopen opens the file path again and closes the previous file. If the path
+// cannot be opened the previous file is kept.
+func (rf *ReopenFile) Reopen() error {
+
+	file, err := os.OpenFile(rf.path, rf.flag, rf.perm)
+	if err != nil {
+		return err
+	}
+
+	rf.Lock()
+	previous := rf.file
+	rf.file = file
+	rf.Unlock()
+
+	if previous != nil {
+		return previous.Close()
+	}
+
+	return nil
+
+}
+
+// Closes the file.
+func (rf *ReopenFile) Close() error {
+
+	rf.Lock()
+	defer rf.Unlock()
+
+	if rf.file == nil {
+		return os.ErrClosed
+	}
+
+	err := rf.file.Close()
+	rf.file = nil
+
+	return err
+
+}
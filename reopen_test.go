@@ -0,0 +1,101 @@
+package soju
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Writes, rotates the file, reopens and writes again.
+func TestReopenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soju")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "service.log")
+	rf, err := OpenReopenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("before\n"))
+
+	// logrotate moves the file
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("still before\n"))
+
+	if err := rf.Reopen(); err != nil {
+		t.Errorf("Reopen() should return nil but returned [%v]", err)
+		return
+	}
+	rf.Write([]byte("after\n"))
+	if err := rf.Close(); err != nil {
+		t.Errorf("Close() should return nil but returned [%v]", err)
+		return
+	}
+	if _, err := rf.Write([]byte("closed\n")); err != os.ErrClosed {
+		t.Errorf("Write() should fail after Close() but returned [%v]", err)
+	}
+
+	rotated, _ := ioutil.ReadFile(path + ".1")
+	if string(rotated) != "before\nstill before\n" {
+		t.Errorf("unexpected rotated file %q", rotated)
+	}
+	current, _ := ioutil.ReadFile(path)
+	if string(current) != "after\n" {
+		t.Errorf("unexpected current file %q", current)
+	}
+}
+
+type reopenWorkerSample struct {
+	ReopenCalled bool
+}
+
+func (rws *reopenWorkerSample) Stop(dn DoneNotifier) (err error) {
+	dn.Done()
+	return
+}
+
+func (rws *reopenWorkerSample) StopNow(dn DoneNotifier) (err error) {
+	dn.Done()
+	return
+}
+
+func (rws *reopenWorkerSample) Reopen() (err error) {
+	rws.ReopenCalled = true
+	return
+}
+
+// Gets user signal 1
+// Reopens the workers files and keeps serving
+func TestReopenOnSIGUSR1(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	w := new(reopenWorkerSample)
+	server.AddWorker(w)
+
+	reopened := make(chan error, 1)
+	server.SetReopenHandler(func(err error) {
+		reopened <- err
+	})
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGUSR1
+		<-reopened
+		server.c <- syscall.SIGTERM
+	}()
+	if result := server.Serve(1*time.Second, 500*time.Millisecond); result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+	if !w.ReopenCalled {
+		t.Errorf("Reopen() method was not called")
+	}
+}
@@ -0,0 +1,283 @@
+package soju
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Namer is an optional interface for the service and workers to be identified
+// in the shutdown report. Components not implementing it are named after
+// their type.
+type Namer interface {
+	Name() string
+}
+
+// Phase tells in which phase of the shutdown a component finished.
+type Phase int
+
+const (
+	// The component finished during the graceful Stop phase.
+	PhaseStop Phase = iota
+	// The component finished during the StopNow phase.
+	PhaseStopNow
+	// The component did not finish before the StopNow timeout.
+	PhaseTimeout
+	// The component was being started.
+	PhaseStart
+	// The component was running, see Runner.
+	PhaseRun
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseStop:
+		return "Stop"
+	case PhaseStopNow:
+		return "StopNow"
+	case PhaseTimeout:
+		return "timeout"
+	case PhaseStart:
+		return "Start"
+	case PhaseRun:
+		return "Run"
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// Exit codes returned by Serve.
+const (
+	// Everything stopped correctly.
+	ExitOK = 0
+	// The server was already running.
+	ExitRunning = 1
+	// Some component did not finish in time.
+	ExitTimeout = 2
+	// Everything stopped, but some component returned an error.
+	ExitErrors = 3
+	// Some component failed to start.
+	ExitStartFailed = 4
+	// The process was forced to exit by a repeated stop signal, see
+	// SetEscalation.
+	ExitForced = 5
+	// A supervised worker exhausted its restarts and the server stopped,
+	// see MaxRestarts.
+	ExitWorkerFailed = 6
+)
+
+// ComponentReport describes how the service or a worker stopped.
+type ComponentReport struct {
+	Name string
+	// The registered Service, Worker, ContextService or ContextWorker.
+	Component interface{}
+	// Shutdown tier, see Tier.
+	Tier  int
+	Phase Phase
+	// Time since the shutdown began until the component finished, or until
+	// the server gave up waiting for it.
+	Duration time.Duration
+	// Errors returned by Stop and StopNow (a timeout is reported as the
+	// context error).
+	StopErr    error
+	StopNowErr error
+}
+
+// Returns the error returned by the component in the phase it finished, nil if
+// it did not return any or if it timed out.
+func (c *ComponentReport) Err() error {
+
+	var err error
+	switch c.Phase {
+	case PhaseStop:
+		err = c.StopErr
+	case PhaseStopNow:
+		err = c.StopNowErr
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	return &ComponentError{Name: c.Name, Phase: c.Phase, Err: err}
+
+}
+
+// ShutdownReport describes how the service and every worker stopped.
+type ShutdownReport struct {
+	// Signal that began the shutdown, nil if it was the context or a
+	// failure.
+	Signal     os.Signal
+	Start      time.Time
+	Duration   time.Duration
+	Components []ComponentReport
+	// Error that made the server stop, like a supervised worker exhausting
+	// its restarts. Nil if it was a signal or the context.
+	Cause error
+}
+
+// Returns the reports of the components that did not finish in time.
+func (r *ShutdownReport) TimedOut() (reports []ComponentReport) {
+	for i := range r.Components {
+		if r.Components[i].Phase == PhaseTimeout {
+			reports = append(reports, r.Components[i])
+		}
+	}
+	return
+}
+
+// Returns the errors returned by the components that finished, nil if there
+// is none.
+func (r *ShutdownReport) Errors() error {
+
+	var errs Errors
+	for i := range r.Components {
+		if err := r.Components[i].Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs.orNil()
+
+}
+
+// Returns the error returned by Run for the shutdown: its cause, ErrTimeout or
+// the errors of the components.
+func (r *ShutdownReport) err() error {
+	if r.Cause != nil {
+		return r.Cause
+	}
+	if len(r.TimedOut()) > 0 {
+		return ErrTimeout
+	}
+	return r.Errors()
+}
+
+// Returns the process exit code for the shutdown: ExitWorkerFailed if it was
+// caused by a failure, ExitTimeout if some component timed out, ExitErrors if
+// some returned an error or ExitOK.
+func (r *ShutdownReport) ExitCode() int {
+	if r.Cause != nil {
+		return ExitWorkerFailed
+	}
+	if len(r.TimedOut()) > 0 {
+		return ExitTimeout
+	}
+	if r.Errors() != nil {
+		return ExitErrors
+	}
+	return ExitOK
+}
+
+// Returns a human readable report, one line per component.
+func (r *ShutdownReport) String() string {
+
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintf(buf, "shutdown on %v took %s\n", r.Signal, r.Duration)
+	if r.Cause != nil {
+		fmt.Fprintf(buf, "caused by: %s\n", r.Cause)
+	}
+	for _, c := range r.Components {
+		fmt.Fprintf(buf, "%s: %s in %s", c.Name, c.Phase, c.Duration)
+		if c.StopErr != nil {
+			fmt.Fprintf(buf, ", Stop error: %s", c.StopErr)
+		}
+		if c.StopNowErr != nil {
+			fmt.Fprintf(buf, ", StopNow error: %s", c.StopNowErr)
+		}
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+
+}
+
+// A component being stopped and its report.
+type component struct {
+	ContextWorker
+
+	mu     sync.Mutex
+	done   bool
+	report ComponentReport
+}
+
+func newComponent(cw ContextWorker, tier int) *component {
+
+	original := unwrap(cw)
+
+	return &component{
+		ContextWorker: cw,
+		report: ComponentReport{
+			Name:      componentName(original),
+			Component: original,
+			Tier:      tier,
+			Phase:     PhaseTimeout,
+		},
+	}
+
+}
+
+// Returns the name of a component: the one given by Name() if it is a Namer or
+// its type otherwise.
+func componentName(original interface{}) string {
+
+	if n, ok := original.(Namer); ok {
+		return n.Name()
+	}
+
+	return fmt.Sprintf("%T", original)
+
+}
+
+// Marks the component as timed out in a phase until it returns.
+func (c *component) begin(phase Phase) {
+	c.finish(phase, context.DeadlineExceeded, false, 0)
+	return
+}
+
+// Records the result of calling the stop method of a phase, which finished
+// elapsed after the shutdown began. It returns the error as a ComponentError
+// if the method returned one other than a timeout.
+func (c *component) finish(phase Phase, err error, finished bool, elapsed time.Duration) *ComponentError {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if phase == PhaseStop {
+		c.report.StopErr = err
+	} else {
+		c.report.StopNowErr = err
+	}
+
+	if finished && !c.done {
+		c.done = true
+		c.report.Phase = phase
+		c.report.Duration = elapsed
+	}
+
+	if err == nil || err == context.DeadlineExceeded {
+		return nil
+	}
+
+	return &ComponentError{Name: c.report.Name, Phase: phase, Err: err}
+
+}
+
+// Returns a copy of the report. Components still running are given the
+// duration of the whole shutdown.
+func (c *component) snapshot(duration time.Duration) ComponentReport {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := c.report
+	if !c.done {
+		report.Duration = duration
+	}
+
+	return report
+
+}
@@ -0,0 +1,173 @@
+package soju
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type namedWorkerSample struct {
+	secondTimeoutSojuTest
+}
+
+func (nws *namedWorkerSample) Name() string {
+	return "hanging worker"
+}
+
+type failingWorkerSample struct{}
+
+func (fws *failingWorkerSample) Stop(dn DoneNotifier) (err error) {
+	dn.Done()
+	return errors.New("queue not flushed")
+}
+func (fws *failingWorkerSample) StopNow(dn DoneNotifier) (err error) {
+	dn.Done()
+	return
+}
+
+// Registers a service, a worker that fails to stop and one that hangs
+// Gets kill signal
+// The report tells how each of them stopped
+func TestShutdownReport(t *testing.T) {
+	server := new(Server)
+	notificable := new(sojuTest)
+	server.SetService(notificable)
+	failing := new(failingWorkerSample)
+	server.AddWorker(failing)
+	hanging := new(namedWorkerSample)
+	server.AddWorker(hanging)
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+	}()
+	code, report := server.ServeReport(200*time.Millisecond, 200*time.Millisecond)
+	if code != 2 {
+		t.Errorf("return code should be 2 but is [%d] instead", code)
+		return
+	}
+	if report.Signal != syscall.SIGTERM {
+		t.Errorf("report signal should be SIGTERM but is [%v]", report.Signal)
+		return
+	}
+	if len(report.Components) != 3 {
+		t.Errorf("report should have 3 components but has %d", len(report.Components))
+		return
+	}
+
+	service := report.Components[0]
+	if service.Name != "*soju.sojuTest" || service.Component != notificable {
+		t.Errorf("unexpected service report %+v", service)
+		return
+	}
+	if service.Phase != PhaseStop || service.StopErr != nil {
+		t.Errorf("the service should stop cleanly but got %+v", service)
+		return
+	}
+
+	worker := report.Components[1]
+	if worker.Phase != PhaseStop || worker.StopErr == nil || worker.StopErr.Error() != "queue not flushed" {
+		t.Errorf("the failing worker should stop with an error but got %+v", worker)
+		return
+	}
+
+	worker = report.Components[2]
+	if worker.Name != "hanging worker" || worker.Phase != PhaseTimeout {
+		t.Errorf("the hanging worker should time out but got %+v", worker)
+		return
+	}
+	if worker.StopErr != context.DeadlineExceeded || worker.StopNowErr != context.DeadlineExceeded {
+		t.Errorf("the hanging worker should report the timeouts but got %+v", worker)
+		return
+	}
+	if worker.Duration < 400*time.Millisecond {
+		t.Errorf("the hanging worker should last both timeouts but lasted %s", worker.Duration)
+		return
+	}
+
+	timedOut := report.TimedOut()
+	if len(timedOut) != 1 || timedOut[0].Component != hanging {
+		t.Errorf("only the hanging worker should time out but got %+v", timedOut)
+		return
+	}
+	if !strings.Contains(report.String(), "hanging worker: timeout in") {
+		t.Errorf("unexpected report string:\n%s", report)
+		return
+	}
+}
+
+type unfinishedWorkerSample struct{}
+
+func (uws *unfinishedWorkerSample) Stop(dn DoneNotifier) (err error) {
+	// returns an error without calling dn.Done()
+	return errors.New("listener not closed")
+}
+func (uws *unfinishedWorkerSample) StopNow(dn DoneNotifier) (err error) {
+	dn.Done()
+	return
+}
+
+// Registers a service and a worker that fails to stop
+// Gets kill signal
+// Everything stops but with errors (returns 3)
+func TestStopErrors(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	server.AddWorker(new(failingWorkerSample))
+
+	handled := make(chan *ComponentError, 1)
+	server.SetStopErrorHandler(func(err *ComponentError) {
+		handled <- err
+	})
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+	}()
+	code, report := server.ServeReport(200*time.Millisecond, 200*time.Millisecond)
+	if code != ExitErrors {
+		t.Errorf("return code should be %d but is [%d] instead", ExitErrors, code)
+		return
+	}
+	errs, ok := report.Errors().(Errors)
+	if !ok || len(errs) != 1 {
+		t.Errorf("the report should have one error but has [%v]", report.Errors())
+		return
+	}
+	if errs[0].Error() != "*soju.failingWorkerSample: Stop: queue not flushed" {
+		t.Errorf("unexpected error [%s]", errs[0])
+		return
+	}
+	select {
+	case err := <-handled:
+		if err.Phase != PhaseStop || err.Err.Error() != "queue not flushed" {
+			t.Errorf("unexpected handled error [%s]", err)
+		}
+	default:
+		t.Errorf("the stop error handler should be called")
+	}
+}
+
+// A worker returning an error without calling Done() is not finished
+// but its error is reported anyway.
+func TestStopErrorNotDone(t *testing.T) {
+	server := new(Server)
+	server.AddWorker(new(unfinishedWorkerSample))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	server.SetTimeouts(100*time.Millisecond, 100*time.Millisecond)
+	report, err := server.RunReport(ctx)
+	if err != nil {
+		t.Errorf("RunReport() should return nil but returned [%v]", err)
+		return
+	}
+	worker := report.Components[0]
+	if worker.Phase != PhaseStopNow || worker.StopErr == nil || worker.StopErr.Error() != "listener not closed" {
+		t.Errorf("the worker should finish on StopNow reporting its Stop error but got %+v", worker)
+		return
+	}
+}
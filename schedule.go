@@ -0,0 +1,196 @@
+package soju
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a scheduled job runs.
+type Schedule interface {
+	// Returns the first time after the given one.
+	Next(time.Time) time.Time
+}
+
+// Every returns a schedule running a job every interval. It panics if the
+// interval is not positive, like time.NewTicker.
+func Every(interval time.Duration) Schedule {
+
+	if interval <= 0 {
+		panic("soju: non-positive interval for Every")
+	}
+
+	return every(interval)
+
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// Cron macros and their expressions.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Ranges of the cron fields.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// ParseCron parses a cron expression: the minute, hour, day of month, month
+// and day of week fields separated by spaces, each of them a "*" or a comma
+// separated list of values and ranges ("1-5"), optionally with a step ("*/15",
+// "0-30/10"). Sunday is 0 (or 7). As in cron, if both days are restricted the
+// job runs on the days matching either. The macros @yearly, @monthly,
+// @weekly, @daily and @hourly are accepted too. Times are in the local zone
+// of the times given to Next.
+func ParseCron(expr string) (Schedule, error) {
+
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("soju: cron expression %q must have %d fields", expr, len(cronFields))
+	}
+
+	cs := new(cronSchedule)
+	sets := []*uint64{&cs.minute, &cs.hour, &cs.dom, &cs.month, &cs.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("soju: cron expression %q, %s: %v", expr, cronFields[i].name, err)
+		}
+		*sets[i] = set
+	}
+
+	// Sunday is 7 too.
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1
+	}
+	cs.anyDom = fields[2] == "*"
+	cs.anyDow = fields[4] == "*"
+
+	return cs, nil
+
+}
+
+// Returns the set of values of a cron field as a bit set.
+func parseCronField(field string, min, max int) (set uint64, err error) {
+
+	// Sunday is 7 too.
+	if max == 6 {
+		max = 7
+	}
+
+	for _, part := range strings.Split(field, ",") {
+
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+
+	}
+
+	return set, nil
+
+}
+
+// A parsed cron expression, every field a bit set of its values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+// Reports whether the day matches the day of month and day of week fields.
+func (cs *cronSchedule) day(t time.Time) bool {
+
+	dom := cs.dom&(1<<uint(t.Day())) != 0
+	dow := cs.dow&(1<<uint(t.Weekday())) != 0
+
+	if cs.anyDom || cs.anyDow {
+		return dom && dow
+	}
+
+	return dom || dow
+
+}
+
+func (cs *cronSchedule) Next(t time.Time) time.Time {
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Every valid expression matches within a few years.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+
+		if cs.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cs.day(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if cs.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if cs.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+
+	}
+
+	// Like 30th of February.
+	return time.Time{}
+
+}
@@ -0,0 +1,273 @@
+package soju
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Scheduler is a Worker running jobs on schedules, like intervals or cron
+// expressions. It implements Runner, so the server runs it while serving. A
+// job is skipped if it is still running when it is due again. Stop refuses to
+// run new jobs and waits for the running ones, StopNow cancels their context
+// too. It implements Starter so that it can run again once stopped, like when
+// it is restarted with its siblings (see SetRestartStrategy).
+type Scheduler struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries []*scheduled
+	changed chan struct{}
+
+	// Guarded by mu, replaced on Start.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping chan struct{}
+
+	running sync.WaitGroup
+
+	errorHandler func(error)
+}
+
+// A scheduled job.
+type scheduled struct {
+	name     string
+	schedule Schedule
+	job      Job
+
+	next    time.Time
+	running bool
+}
+
+// NewScheduler returns a scheduler without jobs.
+func NewScheduler() *Scheduler {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Scheduler{
+		clock:    SystemClock,
+		changed:  make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		stopping: make(chan struct{}),
+	}
+
+}
+
+// Start lets a stopped scheduler run the jobs again. The jobs are next run at
+// the next time of their schedule after now.
+func (sc *Scheduler) Start() (err error) {
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	select {
+	case <-sc.stopping:
+	default:
+		// Not stopped.
+		return
+	}
+
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	sc.stopping = make(chan struct{})
+	now := sc.clock.Now()
+	for _, entry := range sc.entries {
+		entry.next = entry.schedule.Next(now)
+	}
+
+	return
+
+}
+
+// Sets the clock of the scheduler, SystemClock by default.
+func (sc *Scheduler) SetClock(clock Clock) {
+	sc.clock = clock
+	return
+}
+
+// Sets the handler receiving the errors returned by the jobs, as a
+// ComponentError named after the job. It is called from the jobs goroutines.
+func (sc *Scheduler) SetErrorHandler(handler func(error)) {
+	sc.errorHandler = handler
+	return
+}
+
+// Add schedules a job, which first runs at the next time of the schedule
+// after now.
+func (sc *Scheduler) Add(name string, schedule Schedule, job Job) {
+
+	sc.mu.Lock()
+	sc.entries = append(sc.entries, &scheduled{
+		name:     name,
+		schedule: schedule,
+		job:      job,
+		next:     schedule.Next(sc.clock.Now()),
+	})
+	sc.mu.Unlock()
+
+	// Wake up Run to recompute the next job.
+	select {
+	case sc.changed <- struct{}{}:
+	default:
+	}
+
+	return
+
+}
+
+// Schedules a job to run every interval, which must be positive (see Every).
+func (sc *Scheduler) Every(name string, interval time.Duration, job Job) {
+	sc.Add(name, Every(interval), job)
+	return
+}
+
+// Schedules a job on a cron expression, see ParseCron.
+func (sc *Scheduler) Cron(name string, expr string, job Job) error {
+
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return err
+	}
+	sc.Add(name, schedule, job)
+
+	return nil
+
+}
+
+// Run runs the jobs when they are due until the scheduler is stopped.
+func (sc *Scheduler) Run() error {
+
+	sc.mu.Lock()
+	stopping := sc.stopping
+	sc.mu.Unlock()
+
+	for {
+
+		now := sc.clock.Now()
+		next := sc.runDue(now)
+
+		var due <-chan time.Time
+		if !next.IsZero() {
+			due = sc.clock.After(next.Sub(now))
+		}
+
+		select {
+		case <-due:
+		case <-sc.changed:
+		case <-stopping:
+			return nil
+		}
+
+	}
+
+}
+
+// Starts the jobs due at now and returns when the next one is due, zero if
+// there is none.
+func (sc *Scheduler) runDue(now time.Time) (next time.Time) {
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, entry := range sc.entries {
+
+		// The schedule has no more times.
+		if entry.next.IsZero() {
+			continue
+		}
+
+		if !entry.next.After(now) {
+			// Skip the job while it is still running.
+			if !entry.running {
+				sc.start(entry)
+			}
+			entry.next = entry.schedule.Next(now)
+			// A schedule not moving forward would run the job in a loop,
+			// it is given no more times.
+			if !entry.next.After(now) {
+				entry.next = time.Time{}
+				continue
+			}
+		}
+
+		if next.IsZero() || entry.next.Before(next) {
+			next = entry.next
+		}
+
+	}
+
+	return
+
+}
+
+// Runs a job in background, unless stopping. Must be called with the lock
+// held.
+func (sc *Scheduler) start(entry *scheduled) {
+
+	select {
+	case <-sc.stopping:
+		return
+	default:
+	}
+
+	entry.running = true
+	sc.running.Add(1)
+
+	ctx := sc.ctx
+	go func() {
+
+		defer sc.running.Done()
+
+		err := entry.job(ctx)
+		if err != nil && sc.errorHandler != nil {
+			sc.errorHandler(&ComponentError{Name: entry.name, Phase: PhaseRun, Err: err})
+		}
+
+		sc.mu.Lock()
+		entry.running = false
+		sc.mu.Unlock()
+
+	}()
+
+	return
+
+}
+
+// Refuses to run new jobs and returns the function cancelling the running
+// ones.
+func (sc *Scheduler) stop() context.CancelFunc {
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	select {
+	case <-sc.stopping:
+	default:
+		close(sc.stopping)
+	}
+
+	return sc.cancel
+
+}
+
+// Refuses to run new jobs and waits for the running ones.
+func (sc *Scheduler) Stop(dn DoneNotifier) (err error) {
+
+	sc.stop()
+	sc.running.Wait()
+	dn.Done()
+
+	return
+
+}
+
+// Cancels the context of the running jobs and waits for them.
+func (sc *Scheduler) StopNow(dn DoneNotifier) (err error) {
+
+	cancel := sc.stop()
+	cancel()
+	sc.running.Wait()
+	dn.Done()
+
+	return
+
+}
@@ -0,0 +1,272 @@
+package soju
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tekii/soju/sojutest"
+)
+
+// Time the fake clocks start at.
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Runs the scheduler in background.
+func runScheduler(sc *Scheduler) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- sc.Run()
+	}()
+	return done
+}
+
+// A job every minute
+// Runs when due and is skipped while still running
+func TestSchedulerEvery(t *testing.T) {
+	clock := sojutest.NewFakeClock(epoch)
+	sc := NewScheduler()
+	sc.SetClock(clock)
+
+	var runs int64
+	release := make(chan struct{}, 2)
+	sc.Every("job", time.Minute, func(ctx context.Context) error {
+		atomic.AddInt64(&runs, 1)
+		<-release
+		return nil
+	})
+	done := runScheduler(sc)
+
+	clock.BlockUntil(2)
+	clock.Advance(30 * time.Second)
+	if atomic.LoadInt64(&runs) != 0 {
+		t.Errorf("the job shouldn't run before it is due")
+		return
+	}
+
+	// Runs and keeps running.
+	clock.Advance(30 * time.Second)
+	clock.BlockUntil(1)
+	time.Sleep(10 * time.Millisecond)
+	// Skipped.
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt64(&runs) != 1 {
+		t.Errorf("the job should run once but ran %d times", runs)
+		return
+	}
+
+	release <- struct{}{}
+	time.Sleep(10 * time.Millisecond)
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt64(&runs) != 2 {
+		t.Errorf("the job should run twice but ran %d times", runs)
+		return
+	}
+
+	release <- struct{}{}
+	if err := sc.Stop(newDoneNotifier()); err != nil {
+		t.Errorf("Stop() should return nil but returned [%v]", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run() should return nil but returned [%v]", err)
+	}
+}
+
+// A job is running
+// Stop waits for it and no job runs afterwards, StopNow cancels it
+func TestSchedulerStop(t *testing.T) {
+	for _, now := range []bool{false, true} {
+		clock := sojutest.NewFakeClock(epoch)
+		sc := NewScheduler()
+		sc.SetClock(clock)
+
+		var runs int64
+		running := make(chan struct{}, 1)
+		release := make(chan struct{})
+		var jobErr error
+		sc.SetErrorHandler(func(err error) {
+			jobErr = err
+		})
+		sc.Every("job", time.Minute, func(ctx context.Context) error {
+			atomic.AddInt64(&runs, 1)
+			running <- struct{}{}
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		done := runScheduler(sc)
+		clock.BlockUntil(2)
+		clock.Advance(time.Minute)
+		<-running
+
+		stopped := make(chan struct{})
+		go func() {
+			if now {
+				sc.StopNow(newDoneNotifier())
+			} else {
+				sc.Stop(newDoneNotifier())
+			}
+			close(stopped)
+		}()
+		<-done
+
+		if !now {
+			select {
+			case <-stopped:
+				t.Errorf("Stop() should wait for the running job")
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(release)
+		}
+		<-stopped
+
+		clock.Advance(time.Minute)
+		if atomic.LoadInt64(&runs) != 1 {
+			t.Errorf("the job should run once but ran %d times", runs)
+			return
+		}
+		if now && (jobErr == nil || jobErr.Error() != "job: Run: context canceled") {
+			t.Errorf("StopNow() should cancel the job but it returned [%v]", jobErr)
+			return
+		}
+	}
+}
+
+// Parses cron expressions and computes their next times.
+func TestParseCron(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2020, 1, 1, 10, 30, 0, 0, time.UTC)
+	for _, test := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2020, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * *", time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"0 8,20 * * *", time.Date(2020, 1, 1, 20, 0, 0, 0, time.UTC)},
+		{"30 2 * * 0", time.Date(2020, 1, 5, 2, 30, 0, 0, time.UTC)},
+		{"30 2 * * 7", time.Date(2020, 1, 5, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		schedule, err := ParseCron(test.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) should return nil but returned [%v]", test.expr, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(test.next) {
+			t.Errorf("%q should be next at %s but is at %s", test.expr, test.next, next)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) should fail", expr)
+		}
+	}
+}
+
+// Non-positive intervals are refused.
+func TestEveryNonPositive(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Every(%s) should panic", interval)
+				}
+			}()
+			Every(interval)
+		}()
+	}
+}
+
+// A schedule not moving forward.
+type stuckSchedule struct{}
+
+func (stuckSchedule) Next(t time.Time) time.Time {
+	return t
+}
+
+// A schedule returns the given time as the next one
+// The job runs once instead of in a loop
+func TestSchedulerStuckSchedule(t *testing.T) {
+	clock := sojutest.NewFakeClock(epoch)
+	sc := NewScheduler()
+	sc.SetClock(clock)
+
+	var runs int64
+	sc.Add("stuck", stuckSchedule{}, func(ctx context.Context) error {
+		atomic.AddInt64(&runs, 1)
+		return nil
+	})
+	done := runScheduler(sc)
+
+	time.Sleep(50 * time.Millisecond)
+	if err := sc.Stop(newDoneNotifier()); err != nil {
+		t.Errorf("Stop() should return nil but returned [%v]", err)
+		return
+	}
+	<-done
+	if atomic.LoadInt64(&runs) != 1 {
+		t.Errorf("the job should run once but ran %d times", runs)
+	}
+}
+
+// A scheduler is restarted with a failing sibling
+// It runs the jobs again after the restart
+func TestSchedulerRestart(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	server.SetRestartStrategy(OneForAll)
+
+	sc := NewScheduler()
+	var runs int64
+	sc.Every("job", 10*time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt64(&runs, 1)
+		return nil
+	})
+	server.AddWorker(sc, Restart(RestartAlways), RestartBackoff(10*time.Millisecond, time.Second))
+	worker := newRunnerWorker(1, errors.New("failed"))
+	server.AddWorker(worker, Restart(RestartOnFailure), RestartBackoff(10*time.Millisecond, time.Second))
+
+	var restarts int64
+	server.AddEventHandler(func(event Event) {
+		if event.Type == EventRestarting {
+			atomic.AddInt64(&restarts, 1)
+		}
+	})
+
+	after := make(chan int64, 1)
+	go func() {
+		waitRuns(worker, 2)
+		before := atomic.LoadInt64(&runs)
+		time.Sleep(100 * time.Millisecond)
+		after <- atomic.LoadInt64(&runs) - before
+		server.c <- syscall.SIGTERM
+	}()
+	if result := server.Serve(time.Second, time.Second); result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+	if n := <-after; n == 0 {
+		t.Errorf("the jobs should run after the restart")
+		return
+	}
+	if n := atomic.LoadInt64(&restarts); n != 1 {
+		t.Errorf("there should be 1 restart but there are %d", n)
+	}
+}
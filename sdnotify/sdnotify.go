@@ -0,0 +1,254 @@
+// Package sdnotify implements the systemd notification protocol (see
+// sd_notify(3)) so that soju servers can run as Type=notify units.
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tekii/soju"
+)
+
+// Names of the environment variables set by systemd.
+const (
+	// The notification socket.
+	SocketEnv = "NOTIFY_SOCKET"
+	// The watchdog interval in microseconds.
+	WatchdogUsecEnv = "WATCHDOG_USEC"
+	// The process expected to keep the watchdog alive.
+	WatchdogPidEnv = "WATCHDOG_PID"
+)
+
+// A Notifier sends state notifications to systemd.
+type Notifier struct {
+	// Mutex to serialize the notifications
+	sync.Mutex
+
+	conn *net.UnixConn
+
+	// Watchdog interval, zero if disabled.
+	watchdog time.Duration
+	// Closed to stop sending watchdog keep-alives, nil when not sending.
+	stopWatchdog chan struct{}
+}
+
+// New returns a Notifier for the socket in NOTIFY_SOCKET. If the variable is
+// not set (the process is not run by systemd) the returned Notifier discards
+// every notification. The watchdog interval is taken from WATCHDOG_USEC.
+func New() (*Notifier, error) {
+
+	n := &Notifier{}
+
+	if addr := os.Getenv(SocketEnv); addr != "" {
+		var err error
+		n, err = Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n.SetWatchdog(WatchdogInterval())
+
+	return n, nil
+
+}
+
+// WatchdogInterval returns the watchdog interval set by systemd for this
+// process, zero if the watchdog is not enabled.
+func WatchdogInterval() time.Duration {
+
+	if pid := os.Getenv(WatchdogPidEnv); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv(WatchdogUsecEnv), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+
+}
+
+// SetWatchdog sets the watchdog interval used by Watch, zero disables it.
+func (n *Notifier) SetWatchdog(interval time.Duration) {
+	n.Lock()
+	defer n.Unlock()
+	n.watchdog = interval
+	return
+}
+
+// Dial returns a Notifier for the given unixgram socket. Addresses beginning
+// with @ are in the abstract namespace.
+func Dial(addr string) (*Notifier, error) {
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{conn: conn}, nil
+
+}
+
+// Notify sends the state assignments (e.g. "READY=1") in a single message.
+func (n *Notifier) Notify(state ...string) (err error) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if n.conn == nil {
+		return
+	}
+
+	_, err = n.conn.Write([]byte(strings.Join(state, "\n") + "\n"))
+
+	return
+
+}
+
+// Close closes the socket.
+func (n *Notifier) Close() (err error) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if n.conn == nil {
+		return
+	}
+
+	err = n.conn.Close()
+	n.conn = nil
+
+	return
+
+}
+
+// Watch adds the notifier as an event handler of the server and, if the
+// watchdog is enabled, sends WATCHDOG=1 every half of its interval while the
+// server is running and healthy (see soju.HealthChecker). A wedged component
+// stops the keep-alives so that systemd restarts the unit.
+func (n *Notifier) Watch(server *soju.Server) {
+
+	server.AddEventHandler(func(event soju.Event) {
+		switch event.Type {
+		case soju.EventReady:
+			n.startWatchdog(server)
+		case soju.EventStopping:
+			// systemd does not watch stopping units.
+			n.stopWatchdogLoop()
+		}
+		n.Handle(event)
+	})
+
+	return
+
+}
+
+func (n *Notifier) startWatchdog(server *soju.Server) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if n.watchdog <= 0 || n.stopWatchdog != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	n.stopWatchdog = stop
+
+	go n.watchdogLoop(server, n.watchdog/2, stop)
+
+	return
+
+}
+
+func (n *Notifier) stopWatchdogLoop() {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if n.stopWatchdog != nil {
+		close(n.stopWatchdog)
+		n.stopWatchdog = nil
+	}
+
+	return
+
+}
+
+// Checks the server health and sends a keep-alive on every tick.
+func (n *Notifier) watchdogLoop(server *soju.Server, period time.Duration, stop chan struct{}) {
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// A health check that hangs stops the keep-alives too.
+			if err := server.Healthy(); err != nil {
+				n.Notify("STATUS=Unhealthy: " + err.Error())
+				continue
+			}
+			n.Notify("WATCHDOG=1")
+		case <-stop:
+			return
+		}
+	}
+
+}
+
+// Handle notifies systemd of a soju server event. It is meant to be added as
+// an event handler:
+//
+//	server.AddEventHandler(notifier.Handle)
+//
+// Errors sending the notifications are ignored, systemd will act on the
+// missing ones.
+func (n *Notifier) Handle(event soju.Event) {
+
+	switch event.Type {
+
+	case soju.EventReady:
+		n.Notify("READY=1", "STATUS=Serving")
+
+	case soju.EventReconfiguring:
+		n.Notify("RELOADING=1", "STATUS=Reconfiguring")
+
+	case soju.EventReconfigured:
+		status := "STATUS=Serving"
+		if event.Err != nil {
+			status = "STATUS=Serving, reconfiguration failed: " + event.Err.Error()
+		}
+		n.Notify("READY=1", status)
+
+	case soju.EventStopping:
+		n.Notify("STOPPING=1", fmt.Sprintf("STATUS=Stopping on %v", event.Signal))
+
+	case soju.EventPhase:
+		// Ask systemd to wait for the tier to finish the phase.
+		n.Notify(
+			fmt.Sprintf("STATUS=%s tier %d (%s)", event.Phase, event.Tier, event.Timeout),
+			fmt.Sprintf("EXTEND_TIMEOUT_USEC=%d", event.Timeout/time.Microsecond),
+		)
+
+	case soju.EventUpgraded:
+		// The new process takes over the unit.
+		if event.Err == nil {
+			n.Notify(fmt.Sprintf("MAINPID=%d", event.Pid))
+		}
+
+	case soju.EventStopped:
+		n.Notify(fmt.Sprintf("STATUS=Stopped with exit code %d", event.Report.ExitCode()))
+
+	}
+
+	return
+
+}
@@ -0,0 +1,230 @@
+package sdnotify
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tekii/soju"
+)
+
+// Listens on a local unixgram socket standing in for systemd.
+func listen(t *testing.T) (*net.UnixConn, string) {
+
+	dir, err := ioutil.TempDir("", "sdnotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return conn, addr
+
+}
+
+// Reads the next notification.
+func read(t *testing.T, conn *net.UnixConn) string {
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("reading notification: %v", err)
+	}
+
+	return string(buf[:n])
+
+}
+
+type service struct{}
+
+func (s *service) Reconfigure() (err error) {
+	return
+}
+func (s *service) Start(ctx context.Context) (err error) {
+	return
+}
+func (s *service) Stop(ctx context.Context) (err error) {
+	return
+}
+func (s *service) StopNow(ctx context.Context) (err error) {
+	return
+}
+
+// Without NOTIFY_SOCKET the notifications are discarded.
+func TestNoSocket(t *testing.T) {
+	os.Unsetenv(SocketEnv)
+	n, err := New()
+	if err != nil {
+		t.Errorf("New() should return nil but returned [%v]", err)
+		return
+	}
+	if err := n.Notify("READY=1"); err != nil {
+		t.Errorf("Notify() should return nil but returned [%v]", err)
+	}
+}
+
+// Runs a server and checks the notifications received by "systemd".
+func TestServerNotifications(t *testing.T) {
+	conn, addr := listen(t)
+	defer os.RemoveAll(filepath.Dir(addr))
+	defer conn.Close()
+
+	os.Setenv(SocketEnv, addr)
+	defer os.Unsetenv(SocketEnv)
+	n, err := New()
+	if err != nil {
+		t.Errorf("New() should return nil but returned [%v]", err)
+		return
+	}
+	defer n.Close()
+
+	server := new(soju.Server)
+	server.SetContextService(new(service))
+	server.SetTimeouts(2*time.Second, time.Second)
+	server.AddEventHandler(n.Handle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.StartAndRun(ctx)
+	}()
+
+	if msg := read(t, conn); msg != "READY=1\nSTATUS=Serving\n" {
+		t.Errorf("unexpected ready notification %q", msg)
+	}
+
+	server.Reconfigure()
+	if msg := read(t, conn); !strings.HasPrefix(msg, "RELOADING=1\n") {
+		t.Errorf("unexpected reloading notification %q", msg)
+	}
+	if msg := read(t, conn); !strings.HasPrefix(msg, "READY=1\n") {
+		t.Errorf("unexpected reloaded notification %q", msg)
+	}
+
+	cancel()
+	if msg := read(t, conn); msg != "STOPPING=1\nSTATUS=Stopping on <nil>\n" {
+		t.Errorf("unexpected stopping notification %q", msg)
+	}
+	msg := read(t, conn)
+	if !strings.HasPrefix(msg, "STATUS=Stop tier 0 (") || !strings.Contains(msg, "\nEXTEND_TIMEOUT_USEC=") {
+		t.Errorf("unexpected phase notification %q", msg)
+	}
+	if msg := read(t, conn); msg != "STATUS=Stopped with exit code 0\n" {
+		t.Errorf("unexpected stopped notification %q", msg)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("StartAndRun() should return nil but returned [%v]", err)
+	}
+}
+
+type healthWorker struct {
+	sync.Mutex
+	err error
+}
+
+func (hw *healthWorker) Healthy() error {
+	hw.Lock()
+	defer hw.Unlock()
+	return hw.err
+}
+func (hw *healthWorker) setHealth(err error) {
+	hw.Lock()
+	defer hw.Unlock()
+	hw.err = err
+}
+func (hw *healthWorker) Stop(ctx context.Context) (err error) {
+	return
+}
+func (hw *healthWorker) StopNow(ctx context.Context) (err error) {
+	return
+}
+
+// Reads the WATCHDOG_USEC and WATCHDOG_PID variables.
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv(WatchdogUsecEnv)
+	defer os.Unsetenv(WatchdogPidEnv)
+
+	os.Setenv(WatchdogUsecEnv, "2000000")
+	if interval := WatchdogInterval(); interval != 2*time.Second {
+		t.Errorf("the interval should be 2s but is %s", interval)
+	}
+	os.Setenv(WatchdogPidEnv, strconv.Itoa(os.Getpid()))
+	if interval := WatchdogInterval(); interval != 2*time.Second {
+		t.Errorf("the interval should be 2s but is %s", interval)
+	}
+	os.Setenv(WatchdogPidEnv, "1")
+	if interval := WatchdogInterval(); interval != 0 {
+		t.Errorf("the watchdog is for another process but the interval is %s", interval)
+	}
+}
+
+// Keeps the watchdog alive only while the workers are healthy.
+func TestWatchdog(t *testing.T) {
+	conn, addr := listen(t)
+	defer os.RemoveAll(filepath.Dir(addr))
+	defer conn.Close()
+
+	n, err := Dial(addr)
+	if err != nil {
+		t.Errorf("Dial() should return nil but returned [%v]", err)
+		return
+	}
+	defer n.Close()
+	n.SetWatchdog(40 * time.Millisecond)
+
+	server := new(soju.Server)
+	worker := new(healthWorker)
+	server.AddContextWorker(worker)
+	server.SetTimeouts(time.Second, time.Second)
+	n.Watch(server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	if msg := read(t, conn); !strings.HasPrefix(msg, "READY=1\n") {
+		t.Errorf("unexpected ready notification %q", msg)
+	}
+	for i := 0; i < 2; i++ {
+		if msg := read(t, conn); msg != "WATCHDOG=1\n" {
+			t.Errorf("unexpected keep-alive %q", msg)
+		}
+	}
+
+	worker.setHealth(errors.New("queue wedged"))
+	// A keep-alive may have been sent before the change.
+	msg := read(t, conn)
+	if msg == "WATCHDOG=1\n" {
+		msg = read(t, conn)
+	}
+	for i := 0; i < 2; i++ {
+		if msg != "STATUS=Unhealthy: queue wedged\n" {
+			t.Errorf("unexpected unhealthy notification %q", msg)
+		}
+		msg = read(t, conn)
+	}
+
+	cancel()
+	for ; !strings.HasPrefix(msg, "STOPPING=1\n"); msg = read(t, conn) {
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run() should return nil but returned [%v]", err)
+	}
+}
@@ -0,0 +1,181 @@
+package soju
+
+import (
+	"os"
+	"syscall"
+)
+
+// StopMode tells whether the server has to stop after handling a signal.
+type StopMode int
+
+const (
+	// The server keeps serving.
+	NoStop StopMode = iota
+	// The components are asked to Stop, and to StopNow after the stop
+	// timeout.
+	GracefulStop
+	// The components are asked to StopNow.
+	ImmediateStop
+)
+
+// An Action handles a signal received by the server.
+type Action func(*Server, os.Signal) StopMode
+
+// SignalMap binds signals to the actions the server runs when receiving them.
+// The server only listens for the signals in its map.
+type SignalMap map[os.Signal]Action
+
+// ActionStop stops the server gracefully.
+func ActionStop(s *Server, sig os.Signal) StopMode {
+	return GracefulStop
+}
+
+// ActionStopNow stops the server immediately.
+func ActionStopNow(s *Server, sig os.Signal) StopMode {
+	return ImmediateStop
+}
+
+// ActionReconfigure reconfigures the service and workers (see
+// Server.Reconfigure) and keeps serving.
+func ActionReconfigure(s *Server, sig os.Signal) StopMode {
+
+	err := s.Reconfigure()
+	if s.reconfigureHandler != nil {
+		s.reconfigureHandler(err)
+	}
+
+	return NoStop
+
+}
+
+// ActionUpgrade upgrades the server (see Server.Upgrade). Once the new process
+// is ready this one stops gracefully, if the upgrade fails it keeps serving.
+// The upgrade runs in background so that the signals, like the stop ones, are
+// still handled while waiting for the new process. The result is reported by
+// EventUpgraded.
+func ActionUpgrade(s *Server, sig os.Signal) StopMode {
+
+	go s.upgradeAndStop(sig)
+
+	return NoStop
+
+}
+
+// ActionReopen reopens the files of the service and workers (see
+// Server.Reopen) and keeps serving.
+func ActionReopen(s *Server, sig os.Signal) StopMode {
+
+	err := s.Reopen()
+	if s.reopenHandler != nil {
+		s.reopenHandler(err)
+	}
+
+	return NoStop
+
+}
+
+// Callback returns an action calling the function and keeping serving.
+func Callback(callback func(os.Signal)) Action {
+	return func(s *Server, sig os.Signal) StopMode {
+		callback(sig)
+		return NoStop
+	}
+}
+
+// DefaultSignalMap returns the signals the server listens for unless another
+// map is set with SetSignalMap.
+func DefaultSignalMap() SignalMap {
+	return SignalMap{
+		syscall.SIGKILL: ActionStop,
+		syscall.SIGINT:  ActionStop,        // Ctrl + C
+		syscall.SIGTERM: ActionStop,        // kill command
+		syscall.SIGABRT: ActionStopNow,     // Abort
+		syscall.SIGHUP:  ActionReconfigure, // Reconfigure
+		syscall.SIGUSR1: ActionReopen,      // Log rotation
+		syscall.SIGUSR2: ActionUpgrade,     // Upgrade
+		syscall.SIGQUIT: ActionDump,        // Diagnostics
+	}
+}
+
+// Sets the signals the server listens for and their actions, replacing the
+// default ones. It takes effect on the next Run.
+func (s *Server) SetSignalMap(signals SignalMap) {
+	s.signals = signals
+	return
+}
+
+// Returns the signal map in use.
+func (s *Server) signalMap() SignalMap {
+	if s.signals == nil {
+		return DefaultSignalMap()
+	}
+	return s.signals
+}
+
+// Sets the signal map of the default static server.
+func SetSignalMap(signals SignalMap) {
+	defaultSojuServer.SetSignalMap(signals)
+	return
+}
+
+// Exits the process, replaced in tests.
+var exit = os.Exit
+
+// Sets whether repeated stop signals escalate the shutdown, as most command
+// line daemons do: a second stop signal received during the graceful phase
+// jumps straight to the StopNow phase, and a third one exits the process with
+// ExitForced. Stop signals are the one that began the shutdown and those whose
+// action returns a stop mode, like ActionStop, ActionStopNow or an action
+// wrapping them.
+func (s *Server) SetEscalation(escalation bool) {
+	s.escalation = escalation
+	return
+}
+
+// Handles the signals received on c while stopping, until stopped is closed.
+// The actions of the signals other than the one that began the shutdown are
+// still run, so that the diagnostics can be dumped (see ActionDump) or the
+// files reopened, but upgrades are refused. With escalation, the stop signals
+// (the first one, or one whose action returns a stop mode) are counted,
+// escalating on the first one and exiting on the second. Otherwise they are
+// ignored.
+func (s *Server) watchStopping(c <-chan os.Signal, first os.Signal, signals SignalMap, escalate func(), stopped chan struct{}) {
+
+	escalated := false
+	for {
+
+		var sig os.Signal
+		select {
+		case sig = <-c:
+		case <-stopped:
+			return
+		}
+
+		if sig != first {
+			action, ok := signals[sig]
+			if !ok || action(s, sig) == NoStop {
+				continue
+			}
+		}
+
+		if !s.escalation {
+			continue
+		}
+
+		if escalated {
+			exit(ExitForced)
+			return
+		}
+
+		escalated = true
+		escalate()
+
+	}
+
+}
+
+// Sets the escalation mode of the default static server.
+func SetEscalation(escalation bool) {
+	defaultSojuServer.SetEscalation(escalation)
+	return
+}
@@ -0,0 +1,223 @@
+package soju
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Binds SIGQUIT to an immediate stop and SIGUSR1 to a callback
+// Gets user signal 1, runs the callback and keeps serving
+// Gets terminate signal, which is not in the map, and ignores it
+// Gets quit signal and stops now
+func TestSignalMap(t *testing.T) {
+	server := new(Server)
+	notificable := new(sojuTest)
+	server.SetService(notificable)
+
+	called := make(chan os.Signal, 1)
+	server.SetSignalMap(SignalMap{
+		syscall.SIGQUIT: ActionStopNow,
+		syscall.SIGUSR1: Callback(func(sig os.Signal) {
+			called <- sig
+		}),
+	})
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGUSR1
+		<-called
+		server.c <- syscall.SIGTERM
+		server.c <- syscall.SIGQUIT
+	}()
+	code, report := server.ServeReport(1*time.Second, 500*time.Millisecond)
+	if code != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", code)
+		return
+	}
+	if report.Signal != syscall.SIGQUIT {
+		t.Errorf("the server should stop on SIGQUIT but stopped on [%v]", report.Signal)
+		return
+	}
+	if notificable.StopCalled {
+		t.Errorf("Stop() method shouldn't be called")
+		return
+	}
+	if !notificable.StopNowCalled {
+		t.Errorf("StopNow() method was not called.")
+		return
+	}
+}
+
+// The default map keeps the historic signals.
+func TestDefaultSignalMap(t *testing.T) {
+	signals := DefaultSignalMap()
+	server := new(Server)
+	for _, sig := range []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL} {
+		if signals[sig](server, sig) != GracefulStop {
+			t.Errorf("%v should stop gracefully", sig)
+		}
+	}
+	if signals[syscall.SIGABRT](server, syscall.SIGABRT) != ImmediateStop {
+		t.Errorf("SIGABRT should stop immediately")
+	}
+	if signals[syscall.SIGHUP](server, syscall.SIGHUP) != NoStop {
+		t.Errorf("SIGHUP should keep serving")
+	}
+}
+
+// Gets terminate signal, Stop hangs
+// Gets terminate signal again and jumps to StopNow before the stop timeout
+func TestEscalation(t *testing.T) {
+	server := new(Server)
+	notificable := new(firstTimeoutSojuTest)
+	server.SetService(notificable)
+	server.SetEscalation(true)
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+		time.Sleep(time.Millisecond * 100)
+		server.c <- syscall.SIGINT
+	}()
+	code, report := server.ServeReport(5*time.Second, 500*time.Millisecond)
+	if code != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", code)
+		return
+	}
+	if report.Duration > time.Second {
+		t.Errorf("the second signal should skip the stop timeout but the shutdown took %s", report.Duration)
+		return
+	}
+	if !notificable.StopCalled || !notificable.StopNowCalled {
+		t.Errorf("both Stop() and StopNow() should be called")
+		return
+	}
+}
+
+// Gets terminate signal three times while the service hangs
+// The third one forces the exit
+func TestEscalationForcedExit(t *testing.T) {
+	defer func(e func(int)) {
+		exit = e
+	}(exit)
+	exited := make(chan int, 1)
+	exit = func(code int) {
+		exited <- code
+	}
+
+	server := new(Server)
+	server.SetService(new(secondTimeoutSojuTest))
+	server.SetEscalation(true)
+
+	go func() {
+		waitRunning(server)
+		for i := 0; i < 3; i++ {
+			server.c <- syscall.SIGTERM
+			time.Sleep(time.Millisecond * 50)
+		}
+	}()
+	code := server.Serve(5*time.Second, 500*time.Millisecond)
+	if code != ExitTimeout {
+		t.Errorf("return code should be 2 but is [%d] instead", code)
+		return
+	}
+	select {
+	case code := <-exited:
+		if code != ExitForced {
+			t.Errorf("exit code should be %d but is [%d] instead", ExitForced, code)
+		}
+	default:
+		t.Errorf("the third signal should force the exit")
+	}
+}
+
+// Without escalation repeated signals are ignored.
+func TestNoEscalation(t *testing.T) {
+	server := new(Server)
+	notificable := new(firstTimeoutSojuTest)
+	server.SetService(notificable)
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+		time.Sleep(time.Millisecond * 100)
+		server.c <- syscall.SIGTERM
+	}()
+	code, report := server.ServeReport(500*time.Millisecond, 500*time.Millisecond)
+	if code != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", code)
+		return
+	}
+	if report.Duration < 500*time.Millisecond {
+		t.Errorf("the stop timeout should be waited but the shutdown took %s", report.Duration)
+	}
+}
+
+// Gets terminate signal, Stop hangs, and interrupt signal escalates
+// The cancelled Stop is not reported as an error
+func TestEscalationNoStopError(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(firstTimeoutSojuTest))
+	server.SetEscalation(true)
+	var errs []*ComponentError
+	server.SetStopErrorHandler(func(err *ComponentError) {
+		errs = append(errs, err)
+	})
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+		time.Sleep(time.Millisecond * 100)
+		server.c <- syscall.SIGINT
+	}()
+	code, report := server.ServeReport(5*time.Second, 500*time.Millisecond)
+	if code != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", code)
+		return
+	}
+	if len(errs) != 0 {
+		t.Errorf("there should be no stop errors but there are %v", errs)
+		return
+	}
+	if err := report.Components[0].StopErr; err != context.DeadlineExceeded {
+		t.Errorf("the escalated Stop should time out but returned [%v]", err)
+	}
+}
+
+// A custom action logging and then stopping
+// Counts as a stop signal for the escalation
+func TestEscalationWrappedAction(t *testing.T) {
+	server := new(Server)
+	notificable := new(firstTimeoutSojuTest)
+	server.SetService(notificable)
+	server.SetEscalation(true)
+	logged := make(chan os.Signal, 1)
+	signals := DefaultSignalMap()
+	signals[syscall.SIGINT] = func(s *Server, sig os.Signal) StopMode {
+		logged <- sig
+		return ActionStop(s, sig)
+	}
+	server.SetSignalMap(signals)
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+		time.Sleep(time.Millisecond * 100)
+		server.c <- syscall.SIGINT
+	}()
+	code, report := server.ServeReport(5*time.Second, 500*time.Millisecond)
+	if code != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", code)
+		return
+	}
+	if report.Duration > time.Second {
+		t.Errorf("the wrapped action should escalate but the shutdown took %s", report.Duration)
+		return
+	}
+	if sig := <-logged; sig != syscall.SIGINT {
+		t.Errorf("the wrapped action should run for SIGINT but ran for %v", sig)
+	}
+}
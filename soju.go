@@ -1,6 +1,10 @@
 package soju
 
 import (
+	"context"
+	"errors"
+	"io"
+	"math"
 	"os"
 	"os/signal"
 	"sync"
@@ -8,6 +12,13 @@ import (
 	"time"
 )
 
+// ErrRunning is returned by Run when the server is already running.
+var ErrRunning = errors.New("soju: server already running")
+
+// ErrTimeout is returned by Run when the service or some worker did not finish
+// even after the stop now timeout.
+var ErrTimeout = errors.New("soju: timeout stopping the service and workers")
+
 // DoneNotifiers are passed to signal handlers so that the service and workers
 // are able to inform when they have correctly finished.
 type DoneNotifier interface {
@@ -16,15 +27,28 @@ type DoneNotifier interface {
 
 // Default implementation of DoneNotifier
 type DefaultDoneNotifier struct {
-	wg   *sync.WaitGroup
+	done chan struct{}
 	once sync.Once
 }
 
+func newDoneNotifier() *DefaultDoneNotifier {
+	return &DefaultDoneNotifier{done: make(chan struct{})}
+}
+
 func (dn *DefaultDoneNotifier) Done() {
-	dn.once.Do(dn.wg.Done)
+	dn.once.Do(func() {
+		close(dn.done)
+	})
 	return
 }
 
+// A stop requested by the server itself, or through Stop and StopNow, with
+// the signal reported as the cause of the shutdown, if any.
+type stopRequest struct {
+	sig  os.Signal
+	mode StopMode
+}
+
 // A Soju Server receives the OS signals and notifies it's service and all the registered
 // workers.
 type Server struct {
@@ -33,128 +57,455 @@ type Server struct {
 	sync.Mutex
 
 	// Main service
-	service Service
+	service ContextService
 
 	// Workers
-	workers []Worker
+	workers []*registeredWorker
 
-	// Signals notifiers
-	doneNotifiers []DoneNotifier
-	wg            *sync.WaitGroup
+	c       chan os.Signal
+	running bool
 
-	// SIGABRT notifiers
-	stopNowNotifiers []DoneNotifier
-	stopNowWG        *sync.WaitGroup
+	// Stops requested by the server itself, like after an upgrade.
+	stopRequests chan stopRequest
+	// Run in progress, see Stop.
+	root *rootRun
 
-	c           chan os.Signal
-	initialized sync.Once
-	end         chan int
-	endCode     int
+	// Listeners passed to the new process on upgrades, and whether an
+	// upgrade is in progress.
+	listeners []namedListener
+	upgrading bool
 
 	// Timeouts
+	startTimeout   time.Duration
 	stopTimeout    time.Duration
 	stopNowTimeout time.Duration
+	upgradeTimeout time.Duration
+
+	// Signals the server listens for, and their actions.
+	signals SignalMap
+	// Whether repeated stop signals escalate the shutdown.
+	escalation bool
+
+	// Called with the result of every signal triggered reconfiguration.
+	reconfigureHandler func(error)
+
+	// Called with the result of every signal triggered reopening.
+	reopenHandler func(error)
+
+	// Called with every error returned by Stop and StopNow.
+	stopErrorHandler func(*ComponentError)
+
+	// Lifecycle event handlers
+	eventHandlers []func(Event)
+
+	// Lifecycle state of the components, see Dump.
+	states map[ContextWorker]componentState
+	// Where ActionDump writes the diagnostics.
+	dumpOutput io.Writer
+
+	// Supervisor of the workers implementing Runner while serving, and
+	// which workers it restarts together.
+	supervisor *supervisor
+	strategy   RestartStrategy
+	// Error that made the server stop, see fail.
+	cause error
+
+	// Name of the server, see SetName.
+	name string
+	// Shutdown in progress when the server is a worker of another one, and
+	// whether it already stopped.
+	stopping *childStop
+	stopped  bool
+	// Server the nested server was registered in, whose timeouts apply
+	// when none were set, see timeouts.
+	parent *Server
+
+	// Clock driving the timeouts, see SetClock.
+	clock Clock
 }
 
 // Sets the server's managed service.
 func (s *Server) SetService(service Service) {
+	s.service = AdaptService(service)
+	return
+}
+
+// Sets the server's managed context aware service.
+func (s *Server) SetContextService(service ContextService) {
 	s.service = service
 	return
 }
 
-// It also notifies, maybe notifyThenWaitOrTimeout?
-func (s *Server) waitOrTimeout(sig os.Signal, wg *sync.WaitGroup, timeout time.Duration) {
+// Sets the time given to the service and workers to Stop, and then to StopNow
+// if they did not finish in time.
+func (s *Server) SetTimeouts(stopTimeout, stopNowTimeout time.Duration) {
+	s.stopTimeout = stopTimeout
+	s.stopNowTimeout = stopNowTimeout
+	return
+}
+
+// Sets a handler that receives the result of every reconfiguration triggered
+// by a signal (SIGHUP by default). The error is nil when all the components
+// reloaded correctly.
+func (s *Server) SetReconfigureHandler(handler func(error)) {
+	s.reconfigureHandler = handler
+	return
+}
+
+// Sets a handler that receives the errors returned by the Stop and StopNow
+// methods of the service and workers as soon as they finish. It may be called
+// from several goroutines at the same time.
+func (s *Server) SetStopErrorHandler(handler func(*ComponentError)) {
+	s.stopErrorHandler = handler
+	return
+}
+
+// Reconfigure calls Reconfigure() on the service and on every registered worker
+// implementing Reconfigurer. All of them are called even if some fail, and the
+// returned error (if any) is an Errors holding every failure.
+func (s *Server) Reconfigure() error {
+
+	s.emit(Event{Type: EventReconfiguring})
+
+	var errs Errors
+
+	if s.service != nil {
+		if err := s.service.Reconfigure(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, worker := range s.copyWorkers() {
+		if r, ok := unwrap(worker.ContextWorker).(Reconfigurer); ok {
+			if err := r.Reconfigure(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	err := errs.orNil()
+	s.emit(Event{Type: EventReconfigured, Err: err})
+
+	return err
+
+}
+
+// Sets a handler that receives the result of every reopening triggered by a
+// signal (SIGUSR1 by default).
+func (s *Server) SetReopenHandler(handler func(error)) {
+	s.reopenHandler = handler
+	return
+}
+
+// Reopen calls Reopen() on the service and every registered worker implementing
+// Reopener. All of them are called even if some fail, and the returned error
+// (if any) is an Errors holding every failure.
+func (s *Server) Reopen() error {
+
+	var errs Errors
+	for _, component := range s.registered() {
+		if r, ok := unwrap(component).(Reopener); ok {
+			if err := r.Reopen(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs.orNil()
+
+}
+
+// Returns the service (if any) followed by all the registered workers.
+func (s *Server) registered() []ContextWorker {
+
+	var components []ContextWorker
+	if s.service != nil {
+		components = append(components, s.service)
+	}
+	for _, worker := range s.copyWorkers() {
+		components = append(components, worker.ContextWorker)
+	}
+
+	return components
+
+}
+
+// Copies the workers so that they can add or remove workers while being
+// notified.
+func (s *Server) copyWorkers() []*registeredWorker {
 
-	// Notify signal
-	s.notify(sig, s.service)
-	// If any, notify all registered workers.
-	s.NotifyWorkers(sig)
+	s.Lock()
+	defer s.Unlock()
+
+	workers := make([]*registeredWorker, len(s.workers))
+	copy(workers, s.workers)
+
+	return workers
+
+}
+
+// Returns the service (if any) and all the registered workers grouped by
+// tier. The components already stopped in a previous phase keep their state,
+// new ones are appended to known.
+func (s *Server) tiers(known *[]*component) [][]*component {
+
+	current := s.copyWorkers()
+	if s.service != nil {
+		current = append([]*registeredWorker{{ContextWorker: s.service}}, current...)
+	}
+
+	var components []*component
+	for _, rw := range current {
+		var c *component
+		for _, k := range *known {
+			if k.ContextWorker == rw.ContextWorker {
+				c = k
+				break
+			}
+		}
+		if c == nil {
+			c = newComponent(rw.ContextWorker, rw.tier)
+			*known = append(*known, c)
+		}
+		components = append(components, c)
+	}
+
+	return groupTiers(components)
+
+}
+
+// Runs a phase tier by tier, beginning with the first tier not lower than
+// from. Each tier is given an even slice of the time left. It returns true if
+// every tier finished, or the first tier that did not.
+func (s *Server) stopTiers(tiers [][]*component, from int, phase Phase, timeout time.Duration, start time.Time, escalate <-chan struct{}) (bool, int) {
+
+	deadline := s.now().Add(timeout)
+
+	for i := range tiers {
+
+		tier := tiers[i][0].report.Tier
+		if tier < from {
+			continue
+		}
+
+		slice := deadline.Sub(s.now()) / time.Duration(len(tiers)-i)
+		s.emit(Event{Type: EventPhase, Phase: phase, Tier: tier, Timeout: slice})
+		if !s.stopAll(tiers[i], phase, slice, start, escalate) {
+			return false, tier
+		}
+
+	}
+
+	return true, 0
+
+}
+
+// Waits for a signal bound to a stop action, a stop request or for the
+// context to be cancelled, in which case it returns a nil signal and a
+// graceful stop. The
+// actions of the other signals received meanwhile are run and the server
+// keeps serving.
+func (s *Server) waitStopSignal(ctx context.Context, signals SignalMap) (os.Signal, StopMode) {
+
+	for {
+
+		var sig os.Signal
+		select {
+		case sig = <-s.c:
+		case r := <-s.stopRequests:
+			return r.sig, r.mode
+		case <-ctx.Done():
+			return nil, GracefulStop
+		}
+
+		action, ok := signals[sig]
+		if !ok {
+			continue
+		}
+
+		if mode := action(s, sig); mode != NoStop {
+			return sig, mode
+		}
+
+	}
+
+}
+
+// Calls the phase stop method on every component and waits until all of them
+// are done, the timeout expires or escalate is closed. It returns true if every
+// component finished in time.
+func (s *Server) stopAll(components []*component, phase Phase, timeout time.Duration, start time.Time, escalate <-chan struct{}) bool {
+
+	ctx, cancel := s.withTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Worker methods must be called in a goroutine.
+	// If not, the shutdowns are serialized and if one of them hang the whole server hangs.
+	wg := new(sync.WaitGroup)
+	state := StateStopping
+	if phase == PhaseStopNow {
+		state = StateStoppingNow
+	}
+
+	for _, c := range components {
+		c.begin(phase)
+		s.setState(c.ContextWorker, state)
+		wg.Add(1)
+		go func(c *component) {
+			defer wg.Done()
+			var err error
+			if phase == PhaseStop {
+				err = c.Stop(ctx)
+			} else {
+				err = c.StopNow(ctx)
+			}
+			// Returning after the timeout is not finishing.
+			finished := ctx.Err() == nil
+			// Cancelled on an escalation, which is a timeout too and not
+			// an error of the component.
+			if !finished && err == context.Canceled {
+				err = context.DeadlineExceeded
+			}
+			if finished {
+				s.setState(c.ContextWorker, StateStopped)
+			}
+			cerr := c.finish(phase, err, finished, s.now().Sub(start))
+			if cerr != nil && s.stopErrorHandler != nil {
+				s.stopErrorHandler(cerr)
+			}
+		}(c)
+	}
 
-	// This channel will be "signaled" when the WaitingGrup reaches 0
-	waiter := make(chan int, 1)
+	// This channel will be closed when the WaitingGroup reaches 0
+	waiter := make(chan struct{})
 	go func() {
 		wg.Wait()
-		waiter <- 0
+		close(waiter)
 	}()
 
 	// Waits for:
 	select {
-	// 1 - Waiter channel to be signaled. AKA all goroutines are done
-	case ec := <-waiter:
-		// TODO: clear s.doneNotifiers and s.wg?
-		s.end <- ec
-	// 2 - Timeout
-	case <-time.After(timeout):
-
-		// No more wait... stop everything now!
-		if sig == syscall.SIGABRT {
-
-			// Releases the waiting groups using bruteforce
-			for i := range s.doneNotifiers {
-				s.doneNotifiers[i].Done()
-			}
-			for i := range s.stopNowNotifiers {
-				s.stopNowNotifiers[i].Done()
-			}
-			// return code 2 => timeout
-			s.end <- 2
+	// 1 - Waiter channel to be closed. AKA all components are done
+	case <-waiter:
+		return true
+	// 2 - Timeout. Components ignoring their context are left behind.
+	case <-ctx.Done():
+		return false
+	// 3 - Escalation to the next phase.
+	case <-escalate:
+		return false
+	}
 
-			return
+}
 
-		}
+// Runs the shutdown sequence. Unless abort is true the components are asked to
+// Stop first and, if they do not finish before the stop timeout (or escalate
+// is closed), to StopNow. Tiers are stopped in order, and the StopNow
+// escalation begins with the tier that did not finish.
+func (s *Server) shutdown(sig os.Signal, abort bool, escalate <-chan struct{}) *ShutdownReport {
 
-		// Send SIGABRT signal.
-		s.waitOrTimeout(syscall.SIGABRT, s.stopNowWG, s.stopNowTimeout)
+	s.Lock()
+	report := &ShutdownReport{
+		Signal: sig,
+		Start:  s.now(),
+		Cause:  s.cause,
+	}
+	s.Unlock()
+
+	s.emit(Event{Type: EventStopping, Signal: sig})
+
+	var known []*component
 
+	stopTimeout, stopNowTimeout := s.timeouts()
+	stopped := false
+	from := math.MinInt32
+	if !abort {
+		stopped, from = s.stopTiers(s.tiers(&known), from, PhaseStop, stopTimeout, report.Start, escalate)
 	}
 
-	return
+	// No more wait... stop everything now!
+	if !stopped {
+		s.stopTiers(s.tiers(&known), from, PhaseStopNow, stopNowTimeout, report.Start, nil)
+	}
+
+	report.Duration = s.now().Sub(report.Start)
+	for _, c := range known {
+		report.Components = append(report.Components, c.snapshot(report.Duration))
+	}
+
+	s.emit(Event{Type: EventStopped, Report: report})
+
+	return report
 
 }
 
-func (s *Server) initialize() {
+// Marks the server as running and subscribes to the OS signals in the map. It
+// returns ErrRunning if the server is already running.
+func (s *Server) initialize(signals SignalMap) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.running {
+		return ErrRunning
+	}
+	s.running = true
+	s.states = nil
+	s.cause = nil
 
 	s.c = make(chan os.Signal, 1)
-	s.end = make(chan int, 1)
+	s.stopRequests = make(chan stopRequest, 1)
+	s.root = &rootRun{done: make(chan struct{})}
 
-	signal.Notify(
-		s.c,
-		syscall.SIGKILL,
-		syscall.SIGINT,  // Ctrl + C
-		syscall.SIGTERM, // kill command
-		syscall.SIGABRT, // Abort
-	)
+	for sig := range signals {
+		signal.Notify(s.c, sig)
+	}
 
-	s.wg = new(sync.WaitGroup)
-	s.stopNowWG = new(sync.WaitGroup)
+	return nil
 
-	go func() {
+}
 
-		s.wg.Add(1) // count this gorouting as running too
+// Unsubscribes from the OS signals so that other servers (or the next Run of
+// this one) do not compete with this server for them, and hands the result of
+// the run to the pending Stop calls.
+func (s *Server) teardown(err error) {
 
-		sig := <-s.c // wait for os.Signal
+	s.Lock()
+	defer s.Unlock()
 
-		s.wg.Done() // the function is done (well not really but ...)
+	signal.Stop(s.c)
+	s.running = false
 
-		// If signal received is SIGABRT, run StopNow handlers and one timeout.
-		if sig == syscall.SIGABRT {
-			s.waitOrTimeout(sig, s.stopNowWG, s.stopNowTimeout)
-		} else {
-			// Any other signal will run with two timeouts.
-			s.waitOrTimeout(sig, s.wg, s.stopTimeout)
-		}
+	s.root.err = err
+	close(s.root.done)
+	s.root = nil
 
-	}()
+	return
 
 }
 
 // Registers a signalable worker.
-func (s *Server) AddWorker(worker Worker) {
+func (s *Server) AddWorker(worker Worker, opts ...WorkerOption) {
+	s.AddContextWorker(AdaptWorker(worker), opts...)
+	return
+}
+
+// Registers a context aware worker.
+func (s *Server) AddContextWorker(worker ContextWorker, opts ...WorkerOption) {
 
 	s.Lock()
 	defer s.Unlock()
 
-	s.workers = append(s.workers, worker)
+	rw := newRegisteredWorker(worker, opts)
+	s.workers = append(s.workers, rw)
+	if child, ok := unwrap(worker).(*Server); ok && child != s {
+		child.Lock()
+		child.parent = s
+		child.Unlock()
+	}
+	if s.supervisor != nil {
+		s.supervisor.register(rw)
+	}
 
 	return
 
@@ -162,12 +513,29 @@ func (s *Server) AddWorker(worker Worker) {
 
 // Deregisters a signalable worker.
 func (s *Server) RemoveWorker(worker Worker) {
+	s.removeWorker(worker)
+	return
+}
+
+// Deregisters a context aware worker.
+func (s *Server) RemoveContextWorker(worker ContextWorker) {
+	s.removeWorker(worker)
+	return
+}
+
+// Removes the first registered worker that is (or wraps) the given one.
+func (s *Server) removeWorker(worker interface{}) {
 
 	s.Lock()
 	defer s.Unlock()
 
 	for i := range s.workers {
-		if s.workers[i] == worker {
+		if s.workers[i].ContextWorker == worker || unwrap(s.workers[i].ContextWorker) == worker {
+			if child, ok := unwrap(s.workers[i].ContextWorker).(*Server); ok && child != s {
+				child.Lock()
+				child.parent = nil
+				child.Unlock()
+			}
 			copy(s.workers[i:], s.workers[i+1:])
 			s.workers[len(s.workers)-1] = nil
 			s.workers = s.workers[:len(s.workers)-1]
@@ -179,63 +547,116 @@ func (s *Server) RemoveWorker(worker Worker) {
 
 }
 
-// Notify a single worker (or service, luckily soju.Service implements soju.Worker)
-func (s *Server) notify(sig os.Signal, worker Worker) {
-
-	// Create a new notifier.
-	d := &DefaultDoneNotifier{}
+// Notify all workers. A SIGABRT makes them StopNow, any other signal makes
+// them Stop. The workers are notified in background and are not waited for.
+func (s *Server) NotifyWorkers(sig os.Signal) {
 
-	// If signal is SIGABRT, then add the notifier to the stopNow waiting group.
+	stopTimeout, stopNowTimeout := s.timeouts()
+	phase, timeout := PhaseStop, stopTimeout
 	if sig == syscall.SIGABRT {
-		d.wg = s.stopNowWG
-		s.stopNowNotifiers = append(s.stopNowNotifiers, d)
-	} else {
-		// Any other signal goes to the common waiting group.
-		d.wg = s.wg
-		s.doneNotifiers = append(s.doneNotifiers, d)
+		phase, timeout = PhaseStopNow, stopNowTimeout
 	}
 
-	// Add 1 to the waiting group.
-	d.wg.Add(1)
-
-	// Worker methods must be called in a goroutine.
-	// If not, the shutdowns are serialized and if one of them hang the whole server hangs.
-	switch sig {
-	case syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL:
-		// Graceful stop
-		go worker.Stop(d)
-	case syscall.SIGABRT:
-		// Abort now! Timeout has passed!
-		go worker.StopNow(d)
+	var components []*component
+	for _, worker := range s.copyWorkers() {
+		components = append(components, newComponent(worker.ContextWorker, worker.tier))
 	}
 
+	go s.stopAll(components, phase, timeout, s.now(), nil)
+
 	return
 
 }
 
-// Notify all workers.
-func (s *Server) NotifyWorkers(sig os.Signal) {
-	for i := range s.workers {
-		s.notify(sig, s.workers[i])
+// Run starts listening for OS signals and blocks until the service and workers
+// are stopped. Cancelling the context starts the same graceful stop sequence
+// as a SIGTERM. It returns the cause of the shutdown if it was a failure (see
+// MaxRestarts), ErrTimeout if some component did not finish in time, or an
+// Errors holding a ComponentError for every component that returned an error
+// while stopping. Once Run returns the server can be run again.
+func (s *Server) Run(ctx context.Context) error {
+	_, err := s.RunReport(ctx)
+	return err
+}
+
+// RunReport is like Run but it also returns the report of the shutdown.
+func (s *Server) RunReport(ctx context.Context) (*ShutdownReport, error) {
+	return s.run(ctx, false)
+}
+
+// Runs the server, starting the service and workers first if start is true.
+// If they fail to start no report is returned.
+func (s *Server) run(ctx context.Context, start bool) (report *ShutdownReport, err error) {
+
+	signals := s.signalMap()
+	if err = s.initialize(signals); err != nil {
+		return
+	}
+	defer func() {
+		s.teardown(err)
+	}()
+
+	// Signals received while starting wait in the channel.
+	if start {
+		if err = s.Start(ctx); err != nil {
+			return
+		}
+	}
+
+	// Run the workers implementing Runner until the shutdown begins.
+	s.beginSupervision()
+
+	s.emit(Event{Type: EventReady})
+	notifyUpgradeReady()
+
+	sig, mode := s.waitStopSignal(ctx, signals)
+	s.endSupervision()
+
+	s.Lock()
+	s.root.stopping = true
+	s.Unlock()
+
+	// Watch for StopNow calls, and the signals received, while stopping.
+	escalate := make(chan struct{})
+	var once sync.Once
+	escalateNow := func() {
+		once.Do(func() {
+			close(escalate)
+		})
 	}
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go s.watchStopRequests(s.stopRequests, escalateNow, stopped)
+	go s.watchStopping(s.c, sig, signals, escalateNow, stopped)
+
+	// An immediate stop runs the StopNow handlers and one timeout.
+	// A graceful one (or the context) will run with two timeouts.
+	report = s.shutdown(sig, mode == ImmediateStop, escalate)
+	err = report.err()
+
 	return
+
 }
 
 // Serve initializes the server (setting the timeouts) and starts listening for
 // OS signals. It returns an exit code when the service is stopped.
 func (s *Server) Serve(stopTimeout, stopNowTimeout time.Duration) int {
+	code, _ := s.ServeReport(stopTimeout, stopNowTimeout)
+	return code
+}
 
-	s.stopTimeout = stopTimeout
-	s.stopNowTimeout = stopNowTimeout
+// ServeReport is like Serve but it also returns the report of the shutdown,
+// which is nil if the server was already running.
+func (s *Server) ServeReport(stopTimeout, stopNowTimeout time.Duration) (int, *ShutdownReport) {
 
-	// Initialize the server only once.
-	s.initialized.Do(s.initialize)
+	s.SetTimeouts(stopTimeout, stopNowTimeout)
 
-	// waits on waitinggroup (forever)
-	s.wg.Wait()
+	report, err := s.RunReport(context.Background())
+	if err == ErrRunning {
+		return ExitRunning, nil
+	}
 
-	// waits on return code channel
-	return <-s.end
+	return report.ExitCode(), report
 
 }
 
@@ -250,9 +671,23 @@ func SetService(service Service) {
 	return
 }
 
+// SetContextService inits the default soju server and sets a context aware
+// service. Like SetService, it should always be called first.
+func SetContextService(service ContextService) {
+	defaultSojuServer = &Server{}
+	defaultSojuServer.SetContextService(service)
+	return
+}
+
 // Adds a worker to the default static server.
-func AddWorker(worker Worker) {
-	defaultSojuServer.AddWorker(worker)
+func AddWorker(worker Worker, opts ...WorkerOption) {
+	defaultSojuServer.AddWorker(worker, opts...)
+	return
+}
+
+// Adds a context aware worker to the default static server.
+func AddContextWorker(worker ContextWorker, opts ...WorkerOption) {
+	defaultSojuServer.AddContextWorker(worker, opts...)
 	return
 }
 
@@ -262,6 +697,58 @@ func RemoveWorker(worker Worker) {
 	return
 }
 
+// Removes a context aware worker from the default static server.
+func RemoveContextWorker(worker ContextWorker) {
+	defaultSojuServer.RemoveContextWorker(worker)
+	return
+}
+
+// Sets the reconfiguration handler of the default static server.
+func SetReconfigureHandler(handler func(error)) {
+	defaultSojuServer.SetReconfigureHandler(handler)
+	return
+}
+
+// Sets the stop error handler of the default static server.
+func SetStopErrorHandler(handler func(*ComponentError)) {
+	defaultSojuServer.SetStopErrorHandler(handler)
+	return
+}
+
+// Sets the reopen handler of the default static server.
+func SetReopenHandler(handler func(error)) {
+	defaultSojuServer.SetReopenHandler(handler)
+	return
+}
+
+// Reopens the files of the service and workers of the default static server.
+func Reopen() error {
+	return defaultSojuServer.Reopen()
+}
+
+// Reconfigures the service and workers of the default static server.
+func Reconfigure() error {
+	return defaultSojuServer.Reconfigure()
+}
+
+// Sets the timeouts of the default static server, to be used with Run.
+func SetTimeouts(stopTimeout, stopNowTimeout time.Duration) {
+	defaultSojuServer.SetTimeouts(stopTimeout, stopNowTimeout)
+	return
+}
+
+// Runs the default static server until it is stopped by a signal or by the
+// context.
+func Run(ctx context.Context) error {
+	return defaultSojuServer.Run(ctx)
+}
+
+// Starts listening for signals and returns the exit code and the shutdown
+// report.
+func ServeReport(stopTimeout, stopNowTimeout time.Duration) (int, *ShutdownReport) {
+	return defaultSojuServer.ServeReport(stopTimeout, stopNowTimeout)
+}
+
 // Starts listening for signals.
 func Serve(stopTimeout, stopNowTimeout time.Duration) int {
 	return defaultSojuServer.Serve(stopTimeout, stopNowTimeout)
@@ -1,6 +1,8 @@
 package soju
 
 import (
+	"context"
+	"errors"
 	"os"
 	"syscall"
 	"testing"
@@ -488,3 +490,231 @@ func TestStopTwoWorkers(t *testing.T) {
 	}
 
 }
+
+type reconfigurableSojuTest struct {
+	sojuTest
+	ReconfigureCalled int
+	ReconfigureErr    error
+}
+
+func (rst *reconfigurableSojuTest) Reconfigure() (err error) {
+	rst.ReconfigureCalled++
+	return rst.ReconfigureErr
+}
+
+// Stops without deregistering from the default server, which may not be set.
+type reconfigurableWorkerSample struct {
+	sojuTest
+	ReconfigureCalled int
+	ReconfigureErr    error
+}
+
+func (rws *reconfigurableWorkerSample) Reconfigure() (err error) {
+	rws.ReconfigureCalled++
+	return rws.ReconfigureErr
+}
+
+// Registers a service and a reconfigurable worker
+// Gets hang up signal twice
+// Reconfigures both and keeps serving
+// Gets kill signal
+// Stops ok (returns 0)
+func TestReconfigureOnSIGHUP(t *testing.T) {
+	server := new(Server)
+	notificable := new(reconfigurableSojuTest)
+	server.SetService(notificable)
+	w := new(reconfigurableWorkerSample)
+	server.AddWorker(w)
+	// A worker that is not a Reconfigurer must be skipped.
+	server.AddWorker(new(sojuTest))
+
+	results := make(chan error, 2)
+	server.SetReconfigureHandler(func(err error) {
+		results <- err
+	})
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGHUP
+		server.c <- syscall.SIGHUP
+		// wait for both reconfigurations before stopping
+		<-results
+		<-results
+		server.c <- os.Kill
+	}()
+	result := server.Serve(1*time.Second, 500*time.Millisecond)
+	if result != 0 {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+	if notificable.ReconfigureCalled != 2 {
+		t.Errorf("service Reconfigure() should be called twice but was called %d times", notificable.ReconfigureCalled)
+		return
+	}
+	if w.ReconfigureCalled != 2 {
+		t.Errorf("worker Reconfigure() should be called twice but was called %d times", w.ReconfigureCalled)
+		return
+	}
+	if !notificable.StopCalled {
+		t.Errorf("Stop() method was not called")
+		return
+	}
+}
+
+// Reconfigure calls every component even if some of them fail
+// and returns all the errors.
+func TestReconfigureErrors(t *testing.T) {
+	server := new(Server)
+	notificable := &reconfigurableSojuTest{ReconfigureErr: errors.New("service failed")}
+	server.SetService(notificable)
+	w1 := &reconfigurableWorkerSample{ReconfigureErr: errors.New("worker failed")}
+	server.AddWorker(w1)
+	w2 := new(reconfigurableWorkerSample)
+	server.AddWorker(w2)
+
+	err := server.Reconfigure()
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Errorf("Reconfigure() should return Errors but returned [%v]", err)
+		return
+	}
+	if len(errs) != 2 {
+		t.Errorf("Reconfigure() should return 2 errors but returned %d", len(errs))
+		return
+	}
+	if errs.Error() != "service failed; worker failed" {
+		t.Errorf("unexpected error message [%s]", errs.Error())
+		return
+	}
+	if w2.ReconfigureCalled != 1 {
+		t.Errorf("every worker should be reconfigured even if one fails")
+		return
+	}
+
+	w1.ReconfigureErr = nil
+	notificable.ReconfigureErr = nil
+	if err := server.Reconfigure(); err != nil {
+		t.Errorf("Reconfigure() should return nil but returned [%v]", err)
+		return
+	}
+}
+
+// Serves the same server twice in a row.
+// Both times it gets kill signal, stops and returns 0.
+func TestServeTwice(t *testing.T) {
+	server := new(Server)
+	for i := 0; i < 2; i++ {
+		notificable := new(sojuTest)
+		server.SetService(notificable)
+		go func() {
+			waitRunning(server)
+			server.c <- os.Kill
+		}()
+		result := server.Serve(1*time.Second, 500*time.Millisecond)
+		if result != 0 {
+			t.Errorf("run %d: return code should be 0 but is [%d] instead", i, result)
+			return
+		}
+		if !notificable.StopCalled {
+			t.Errorf("run %d: Stop() method was not called", i)
+			return
+		}
+	}
+}
+
+// Running a server that is already running fails.
+func TestRunAlreadyRunning(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	server.SetTimeouts(1*time.Second, 500*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+	waitRunning(server)
+
+	if err := server.Run(context.Background()); err != ErrRunning {
+		t.Errorf("second Run() should return ErrRunning but returned [%v]", err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("first Run() should return nil but returned [%v]", err)
+	}
+}
+
+// Two servers run at the same time and both get the OS signals.
+func TestConcurrentServers(t *testing.T) {
+	servers := []*Server{new(Server), new(Server)}
+	ctx, cancel := context.WithCancel(context.Background())
+	reconfigured := make(chan error, len(servers))
+	done := make(chan error, len(servers))
+	for _, server := range servers {
+		server.SetService(new(reconfigurableSojuTest))
+		server.SetTimeouts(1*time.Second, 500*time.Millisecond)
+		server.SetReconfigureHandler(func(err error) {
+			reconfigured <- err
+		})
+		go func(server *Server) {
+			done <- server.Run(ctx)
+		}(server)
+	}
+	for _, server := range servers {
+		waitRunning(server)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	for range servers {
+		select {
+		case <-reconfigured:
+		case <-time.After(time.Second):
+			t.Errorf("both servers should be reconfigured")
+		}
+	}
+
+	cancel()
+	for range servers {
+		if err := <-done; err != nil {
+			t.Errorf("Run() should return nil but returned [%v]", err)
+		}
+	}
+}
+
+// Waits until the server is subscribed to the OS signals.
+func waitRunning(server *Server) {
+	for {
+		server.Lock()
+		running := server.running
+		server.Unlock()
+		if running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type unhealthyWorkerSample struct {
+	workerSample
+}
+
+func (uws *unhealthyWorkerSample) Healthy() error {
+	return errors.New("wedged")
+}
+
+// Only the components implementing HealthChecker are checked.
+func TestHealthy(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	server.AddWorker(new(workerSample))
+	if err := server.Healthy(); err != nil {
+		t.Errorf("Healthy() should return nil but returned [%v]", err)
+		return
+	}
+	server.AddWorker(new(unhealthyWorkerSample))
+	if err := server.Healthy(); err == nil || err.Error() != "wedged" {
+		t.Errorf("Healthy() should return the worker error but returned [%v]", err)
+		return
+	}
+}
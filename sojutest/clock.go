@@ -0,0 +1,117 @@
+// Package sojutest provides helpers to test soju servers and components
+// without waiting for real time to pass.
+package sojutest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a soju.Clock that only moves when told to. The timers created
+// with After fire when Advance moves the time past them.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []timer
+	changed chan struct{}
+}
+
+// A pending After.
+type timer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFakeClock returns a clock stopped at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+func (fc *FakeClock) Now() time.Time {
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.now
+
+}
+
+// After returns a channel receiving the time once the clock is advanced by d.
+// A non positive d fires at once.
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- fc.now
+		return c
+	}
+	fc.timers = append(fc.timers, timer{at: fc.now.Add(d), c: c})
+	fc.notify()
+
+	return c
+
+}
+
+// Advance moves the time forward by d, firing the timers due.
+func (fc *FakeClock) Advance(d time.Duration) {
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.now = fc.now.Add(d)
+
+	var pending []timer
+	for _, t := range fc.timers {
+		if t.at.After(fc.now) {
+			pending = append(pending, t)
+		} else {
+			t.c <- fc.now
+		}
+	}
+	fc.timers = pending
+	fc.notify()
+
+	return
+
+}
+
+// Timers returns the number of timers waiting for the clock.
+func (fc *FakeClock) Timers() int {
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return len(fc.timers)
+
+}
+
+// BlockUntil waits until there are at least n timers waiting for the clock,
+// so that the code under test is known to be waiting before advancing it.
+// Timers abandoned by the code under test are counted until they fire.
+func (fc *FakeClock) BlockUntil(n int) {
+
+	for {
+		fc.mu.Lock()
+		if len(fc.timers) >= n {
+			fc.mu.Unlock()
+			return
+		}
+		changed := fc.changed
+		fc.mu.Unlock()
+		<-changed
+	}
+
+}
+
+// Wakes up the BlockUntil calls. Must be called with the lock held.
+func (fc *FakeClock) notify() {
+
+	close(fc.changed)
+	fc.changed = make(chan struct{})
+
+	return
+
+}
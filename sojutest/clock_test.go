@@ -0,0 +1,45 @@
+package sojutest
+
+import (
+	"testing"
+	"time"
+)
+
+// Timers fire once the clock is advanced past them
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	go func() {
+		clock.BlockUntil(2)
+		clock.Advance(time.Minute)
+	}()
+	minute := clock.After(time.Minute)
+	hour := clock.After(time.Hour)
+
+	if now := <-minute; !now.Equal(start.Add(time.Minute)) {
+		t.Errorf("the timer should fire at %s but fired at %s", start.Add(time.Minute), now)
+		return
+	}
+	select {
+	case <-hour:
+		t.Errorf("the timer shouldn't fire before an hour")
+		return
+	default:
+	}
+	if clock.Timers() != 1 {
+		t.Errorf("there should be 1 timer but there are %d", clock.Timers())
+		return
+	}
+
+	clock.Advance(time.Hour)
+	if now := <-hour; !now.Equal(start.Add(61 * time.Minute)) {
+		t.Errorf("the timer should fire at %s but fired at %s", start.Add(61*time.Minute), now)
+		return
+	}
+	select {
+	case <-clock.After(0):
+	default:
+		t.Errorf("a zero timer should fire at once")
+	}
+}
@@ -0,0 +1,135 @@
+package soju
+
+import (
+	"context"
+	"time"
+)
+
+// Starter is an optional interface for workers that the server has to start,
+// see Server.Start.
+type Starter interface {
+	Start() error
+}
+
+// ContextStarter is the context aware variant of Starter. The context expires
+// when the start timeout is reached.
+type ContextStarter interface {
+	Start(context.Context) error
+}
+
+// Returns the start method of a component, nil if it has none.
+func startMethod(cw ContextWorker) func(context.Context) error {
+
+	switch starter := unwrap(cw).(type) {
+	case ContextStarter:
+		return starter.Start
+	case Starter:
+		return func(ctx context.Context) error {
+			return callContext(ctx, starter.Start)
+		}
+	}
+
+	return nil
+
+}
+
+// Sets the time given to the service and workers to start, zero means no
+// timeout.
+func (s *Server) SetStartTimeout(startTimeout time.Duration) {
+	s.startTimeout = startTimeout
+	return
+}
+
+// Start starts the service and every worker implementing Starter (or
+// ContextStarter), one after the other in the opposite order of the shutdown:
+// the highest tier first. If one of them fails, or the start timeout (or the
+// context) expires, the components already started are stopped in reverse
+// order and the error is returned as a ComponentError.
+func (s *Server) Start(ctx context.Context) error {
+
+	if s.startTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = s.withTimeout(ctx, s.startTimeout)
+		defer cancel()
+	}
+
+	// A stopped nested server can be stopped again once started.
+	s.Lock()
+	s.stopped = false
+	s.Unlock()
+
+	var known, started []*component
+
+	tiers := s.tiers(&known)
+	for i := len(tiers) - 1; i >= 0; i-- {
+		for _, c := range tiers[i] {
+
+			start := startMethod(c.ContextWorker)
+			if start == nil {
+				continue
+			}
+
+			s.setState(c.ContextWorker, StateStarting)
+			if err := start(ctx); err != nil {
+				s.setState(c.ContextWorker, StateStopped)
+				s.rollback(started)
+				return &ComponentError{Name: c.report.Name, Phase: PhaseStart, Err: err}
+			}
+			s.setState(c.ContextWorker, StateRunning)
+			started = append(started, c)
+
+		}
+	}
+
+	return nil
+
+}
+
+// Stops the started components in reverse order. Each of them is given the
+// stop timeout and, if it does not finish, the stop now timeout.
+func (s *Server) rollback(started []*component) {
+
+	for i := len(started) - 1; i >= 0; i-- {
+		c := []*component{started[i]}
+		now := s.now()
+		stopTimeout, stopNowTimeout := s.timeouts()
+		if !s.stopAll(c, PhaseStop, stopTimeout, now, nil) {
+			s.stopAll(c, PhaseStopNow, stopNowTimeout, now, nil)
+		}
+	}
+
+	return
+
+}
+
+// StartAndRun is like Run but it starts the service and workers (see Start)
+// before waiting for signals. If they fail to start the error is returned.
+func (s *Server) StartAndRun(ctx context.Context) error {
+	_, err := s.run(ctx, true)
+	return err
+}
+
+// StartAndServe is like Serve but it starts the service and workers (see
+// Start) before waiting for signals. It returns ExitStartFailed if they fail to
+// start.
+func (s *Server) StartAndServe(startTimeout, stopTimeout, stopNowTimeout time.Duration) int {
+
+	s.SetStartTimeout(startTimeout)
+	s.SetTimeouts(stopTimeout, stopNowTimeout)
+
+	report, err := s.run(context.Background(), true)
+	if cerr, ok := err.(*ComponentError); ok && cerr.Phase == PhaseStart {
+		return ExitStartFailed
+	}
+	if err == ErrRunning {
+		return ExitRunning
+	}
+
+	return report.ExitCode()
+
+}
+
+// Starts the default static server and starts listening for signals.
+func StartAndServe(startTimeout, stopTimeout, stopNowTimeout time.Duration) int {
+	return defaultSojuServer.StartAndServe(startTimeout, stopTimeout, stopNowTimeout)
+}
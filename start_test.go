@@ -0,0 +1,134 @@
+package soju
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type startWorkerSample struct {
+	tierWorkerSample
+	// If set, Start fails with this error.
+	StartErr error
+	// If true, Start blocks until the context expires.
+	HangStart bool
+}
+
+func (sws *startWorkerSample) Start(ctx context.Context) (err error) {
+	sws.recorder.record(sws.name + ".Start")
+	if sws.HangStart {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return sws.StartErr
+}
+
+// Stops without deregistering from the default server, which may not be set.
+type legacyStarterSample struct {
+	sojuTest
+	StartCalled bool
+}
+
+func (lss *legacyStarterSample) Start() (err error) {
+	lss.StartCalled = true
+	return
+}
+
+func newStartWorker(name string, recorder *stopRecorder) *startWorkerSample {
+	return &startWorkerSample{tierWorkerSample: tierWorkerSample{name: name, recorder: recorder}}
+}
+
+// Starts the components from the highest tier to the lowest one,
+// skipping workers that are not Starters.
+func TestStartOrder(t *testing.T) {
+	recorder := new(stopRecorder)
+	server := new(Server)
+	server.SetContextService(newStartWorker("service", recorder))
+	server.AddContextWorker(newStartWorker("frontend", recorder), Tier(-1))
+	server.AddContextWorker(newStartWorker("db", recorder), Tier(2))
+	server.AddContextWorker(new(contextWorkerSample))
+	legacy := new(legacyStarterSample)
+	server.AddWorker(legacy, Tier(1))
+
+	if err := server.Start(context.Background()); err != nil {
+		t.Errorf("Start() should return nil but returned [%v]", err)
+		return
+	}
+	checkCalls(t, recorder, "db.Start", "service.Start", "frontend.Start")
+	if !legacy.StartCalled {
+		t.Errorf("the legacy Starter worker should be started")
+	}
+}
+
+// A component fails to start
+// The ones already started are stopped in reverse order
+func TestStartRollback(t *testing.T) {
+	recorder := new(stopRecorder)
+	server := new(Server)
+	server.SetTimeouts(100*time.Millisecond, 100*time.Millisecond)
+	server.SetContextService(newStartWorker("service", recorder))
+	server.AddContextWorker(newStartWorker("queue", recorder), Tier(1))
+	server.AddContextWorker(newStartWorker("db", recorder), Tier(2))
+	failing := newStartWorker("frontend", recorder)
+	failing.StartErr = errors.New("address in use")
+	server.AddContextWorker(failing, Tier(-1))
+
+	err := server.Start(context.Background())
+	cerr, ok := err.(*ComponentError)
+	if !ok || cerr.Phase != PhaseStart || cerr.Err != failing.StartErr {
+		t.Errorf("Start() should return the frontend error but returned [%v]", err)
+		return
+	}
+	checkCalls(t, recorder,
+		"db.Start", "queue.Start", "service.Start", "frontend.Start",
+		"service.Stop", "queue.Stop", "db.Stop")
+}
+
+// A component hangs while starting
+// The start timeout expires and the started ones are stopped
+func TestStartTimeout(t *testing.T) {
+	recorder := new(stopRecorder)
+	server := new(Server)
+	server.SetTimeouts(100*time.Millisecond, 100*time.Millisecond)
+	server.SetContextService(newStartWorker("service", recorder))
+	hanging := newStartWorker("db", recorder)
+	hanging.HangStart = true
+	server.AddContextWorker(hanging)
+
+	server.SetStartTimeout(100 * time.Millisecond)
+	err := server.Start(context.Background())
+	cerr, ok := err.(*ComponentError)
+	if !ok || cerr.Err != context.DeadlineExceeded {
+		t.Errorf("Start() should return a timeout but returned [%v]", err)
+		return
+	}
+	checkCalls(t, recorder, "service.Start", "db.Start", "service.Stop")
+}
+
+// Starts the service, gets kill signal, stops ok (returns 0)
+func TestStartAndServe(t *testing.T) {
+	recorder := new(stopRecorder)
+	server := new(Server)
+	server.SetContextService(newStartWorker("service", recorder))
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGTERM
+	}()
+	result := server.StartAndServe(100*time.Millisecond, 1*time.Second, 500*time.Millisecond)
+	if result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+	checkCalls(t, recorder, "service.Start", "service.Stop")
+
+	failing := newStartWorker("db", recorder)
+	failing.StartErr = errors.New("connection refused")
+	server.AddContextWorker(failing)
+	result = server.StartAndServe(100*time.Millisecond, 1*time.Second, 500*time.Millisecond)
+	if result != ExitStartFailed {
+		t.Errorf("return code should be %d but is [%d] instead", ExitStartFailed, result)
+		return
+	}
+}
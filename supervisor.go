@@ -0,0 +1,465 @@
+package soju
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Runner is an optional interface for workers doing their job in a blocking
+// Run method. The server runs them in background while serving and, depending
+// on their restart policy, restarts them when Run returns unexpectedly. Stop
+// and StopNow must make Run return.
+type Runner interface {
+	Run() error
+}
+
+// ErrExited is reported when a worker Run returns nil but it was not expected
+// to exit.
+var ErrExited = errors.New("soju: worker exited")
+
+// RestartPolicy tells when a worker is restarted after its Run returns while
+// the server is serving.
+type RestartPolicy int
+
+const (
+	// The worker is not restarted.
+	RestartNever RestartPolicy = iota
+	// The worker is restarted if Run returns an error.
+	RestartOnFailure
+	// The worker is always restarted.
+	RestartAlways
+)
+
+// Default backoff between restarts, see RestartBackoff.
+const (
+	DefaultRestartBackoff    = 100 * time.Millisecond
+	DefaultMaxRestartBackoff = 30 * time.Second
+)
+
+// Restart sets the restart policy of a worker implementing Runner, RestartNever
+// by default.
+func Restart(policy RestartPolicy) WorkerOption {
+	return func(o *workerOptions) {
+		o.restart = policy
+	}
+}
+
+// RestartBackoff sets the time waited before restarting a worker, which is
+// doubled on each consecutive restart up to max. It is reset once the worker
+// runs for longer than max.
+func RestartBackoff(min, max time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.backoff = min
+		o.maxBackoff = max
+	}
+}
+
+// MaxRestarts sets how many times a worker may be restarted within the window.
+// Once exceeded, the worker is not restarted and the whole server is stopped
+// gracefully, reporting the worker error as the shutdown cause. Zero, the
+// default, means no limit.
+func MaxRestarts(restarts int, window time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.maxRestarts = restarts
+		o.window = window
+	}
+}
+
+// RestartStrategy tells which workers are restarted with a worker that exits,
+// as in Erlang supervisors.
+type RestartStrategy int
+
+const (
+	// Only the worker that exited is restarted.
+	OneForOne RestartStrategy = iota
+	// Every supervised worker is stopped and restarted with it.
+	OneForAll
+	// The supervised workers registered after it are stopped and restarted
+	// with it.
+	RestForOne
+)
+
+// Sets which workers are restarted when a supervised worker exits, OneForOne
+// by default. The other workers are stopped in reverse registration order,
+// each of them given the stop timeout and then the stop now timeout, and all
+// of them are started again (see Starter) and run in registration order once
+// the backoff of the worker that exited expires.
+func (s *Server) SetRestartStrategy(strategy RestartStrategy) {
+	s.strategy = strategy
+	return
+}
+
+// Supervises the workers implementing Runner, and the nested servers, while
+// the server is serving.
+type supervisor struct {
+	s      *Server
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	children []*child
+	added    chan *registeredWorker
+	exits    chan childExit
+	restarts chan childRestart
+}
+
+// A supervised worker.
+type child struct {
+	rw   *registeredWorker
+	name string
+	run  func() error
+
+	// Incremented on every run, exits of previous runs are ignored.
+	generation int
+	running    bool
+	started    time.Time
+
+	backoff  time.Duration
+	restarts []time.Time
+}
+
+// A supervised worker returned from a run.
+type childExit struct {
+	c          *child
+	generation int
+	err        error
+}
+
+// Workers to restart once the backoff of the one that exited expires, with
+// their generations when it was scheduled.
+type childRestart struct {
+	children    []*child
+	generations []int
+}
+
+// Begins supervising the registered workers. It returns a context cancelled
+// when the supervision ends.
+func (s *Server) beginSupervision() context.Context {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := &supervisor{
+		s:        s,
+		ctx:      ctx,
+		cancel:   cancel,
+		added:    make(chan *registeredWorker),
+		exits:    make(chan childExit),
+		restarts: make(chan childRestart),
+	}
+
+	// Workers registered from now on are handed to the loop.
+	s.Lock()
+	s.supervisor = sup
+	workers := make([]*registeredWorker, len(s.workers))
+	copy(workers, s.workers)
+	s.Unlock()
+
+	for _, rw := range workers {
+		sup.add(rw)
+	}
+	go sup.loop()
+
+	return ctx
+
+}
+
+// Stops supervising, the workers exiting from now on are not restarted.
+func (s *Server) endSupervision() {
+
+	s.Lock()
+	sup := s.supervisor
+	s.supervisor = nil
+	s.Unlock()
+
+	if sup != nil {
+		sup.cancel()
+	}
+
+	return
+
+}
+
+// Supervises a worker registered while supervising. Must be called with the
+// server lock held, so the worker is handed to the loop in background.
+func (sup *supervisor) register(rw *registeredWorker) {
+
+	go func() {
+		select {
+		case sup.added <- rw:
+		case <-sup.ctx.Done():
+		}
+	}()
+
+	return
+
+}
+
+func (sup *supervisor) loop() {
+
+	for {
+		select {
+		case rw := <-sup.added:
+			sup.add(rw)
+		case e := <-sup.exits:
+			sup.exited(e)
+		case r := <-sup.restarts:
+			sup.restart(r)
+		case <-sup.ctx.Done():
+			return
+		}
+	}
+
+}
+
+// Begins running a worker if it implements Runner or is a nested server.
+func (sup *supervisor) add(rw *registeredWorker) {
+
+	var run func() error
+	switch original := unwrap(rw.ContextWorker).(type) {
+	case *Server:
+		run = original.serveChild
+	case Runner:
+		run = original.Run
+	default:
+		return
+	}
+
+	c := &child{
+		rw:      rw,
+		name:    componentName(unwrap(rw.ContextWorker)),
+		run:     run,
+		backoff: rw.backoff,
+	}
+	sup.children = append(sup.children, c)
+	sup.start(c, false)
+
+	return
+
+}
+
+// Runs a worker in background, starting it first if restarting.
+func (sup *supervisor) start(c *child, restart bool) {
+
+	c.generation++
+	c.running = true
+	c.started = sup.s.now()
+	sup.s.setState(c.rw.ContextWorker, StateRunning)
+
+	go func(generation int) {
+
+		var err error
+		if start := startMethod(c.rw.ContextWorker); restart && start != nil {
+			err = start(sup.ctx)
+		}
+		if err == nil {
+			err = c.run()
+		}
+
+		select {
+		case sup.exits <- childExit{c: c, generation: generation, err: err}:
+		case <-sup.ctx.Done():
+		}
+
+	}(c.generation)
+
+	return
+
+}
+
+// Handles the exit of a worker, restarting it according to its policy.
+func (sup *supervisor) exited(e childExit) {
+
+	c, err := e.c, e.err
+	if e.generation != c.generation || !c.running {
+		return
+	}
+	c.running = false
+
+	rw, s := c.rw, sup.s
+	if !s.isRegistered(rw) {
+		return
+	}
+
+	if rw.restart == RestartNever || (rw.restart == RestartOnFailure && err == nil) {
+		s.setState(rw.ContextWorker, StateStopped)
+		return
+	}
+
+	if err == nil {
+		err = ErrExited
+	}
+
+	now := s.now()
+	if rw.maxRestarts > 0 {
+		// Forget the restarts out of the window.
+		for len(c.restarts) > 0 && now.Sub(c.restarts[0]) > rw.window {
+			c.restarts = c.restarts[1:]
+		}
+		if len(c.restarts) >= rw.maxRestarts {
+			s.setState(rw.ContextWorker, StateStopped)
+			s.fail(&ComponentError{Name: c.name, Phase: PhaseRun, Err: err})
+			return
+		}
+	}
+	c.restarts = append(c.restarts, now)
+
+	if now.Sub(c.started) > rw.maxBackoff {
+		c.backoff = rw.backoff
+	}
+
+	siblings := sup.siblings(c)
+	stopping := sup.stop(siblings)
+
+	restarting := sup.ordered(append(siblings, c))
+	r := childRestart{children: restarting}
+	for _, sibling := range restarting {
+		r.generations = append(r.generations, sibling.generation)
+	}
+	s.setState(rw.ContextWorker, StateRestarting)
+	s.emit(Event{Type: EventRestarting, Name: c.name, Err: err, Timeout: c.backoff})
+
+	backoff := c.backoff
+	c.backoff *= 2
+	if c.backoff > rw.maxBackoff {
+		c.backoff = rw.maxBackoff
+	}
+
+	// Stopping the siblings and the backoff must not block the other
+	// workers, the restart is handed back to the loop once done.
+	go func() {
+
+		s.rollback(stopping)
+		for _, sibling := range siblings {
+			s.setState(sibling.rw.ContextWorker, StateRestarting)
+		}
+
+		select {
+		case <-s.after(backoff):
+		case <-sup.ctx.Done():
+			return
+		}
+
+		select {
+		case sup.restarts <- r:
+		case <-sup.ctx.Done():
+		}
+
+	}()
+
+	return
+
+}
+
+// Restarts the workers in registration order once the backoff expired,
+// skipping those restarted or removed meanwhile.
+func (sup *supervisor) restart(r childRestart) {
+
+	for i, c := range r.children {
+		if c.generation != r.generations[i] || c.running || !sup.s.isRegistered(c.rw) {
+			continue
+		}
+		sup.start(c, true)
+	}
+
+	return
+
+}
+
+// Returns the running workers to restart with one that exited, according to
+// the restart strategy, in registration order.
+func (sup *supervisor) siblings(c *child) (siblings []*child) {
+
+	order := sup.order()
+
+	for _, other := range sup.children {
+		if other == c || !other.running {
+			continue
+		}
+		switch sup.s.strategy {
+		case OneForAll:
+			siblings = append(siblings, other)
+		case RestForOne:
+			if order[other.rw] > order[c.rw] {
+				siblings = append(siblings, other)
+			}
+		}
+	}
+
+	return sup.ordered(siblings)
+
+}
+
+// Returns the position of every registered worker.
+func (sup *supervisor) order() map[*registeredWorker]int {
+
+	order := make(map[*registeredWorker]int)
+	for i, rw := range sup.s.copyWorkers() {
+		order[rw] = i
+	}
+
+	return order
+
+}
+
+// Sorts the workers in registration order.
+func (sup *supervisor) ordered(children []*child) []*child {
+
+	order := sup.order()
+	sort.SliceStable(children, func(i, j int) bool {
+		return order[children[i].rw] < order[children[j].rw]
+	})
+
+	return children
+
+}
+
+// Ignores the exits of the workers and returns them as components to be
+// stopped in reverse order, see rollback.
+func (sup *supervisor) stop(children []*child) (components []*component) {
+
+	for _, c := range children {
+		c.generation++
+		c.running = false
+		components = append(components, newComponent(c.rw.ContextWorker, c.rw.tier))
+	}
+
+	return
+
+}
+
+// Reports whether the worker is still registered.
+func (s *Server) isRegistered(rw *registeredWorker) bool {
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, w := range s.workers {
+		if w == rw {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// Stops the server gracefully because of an error, which is reported as the
+// cause of the shutdown. Only the first error is kept.
+func (s *Server) fail(err error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.cause != nil || s.stopRequests == nil {
+		return
+	}
+	s.cause = err
+
+	select {
+	case s.stopRequests <- stopRequest{mode: GracefulStop}:
+	default:
+	}
+
+	return
+
+}
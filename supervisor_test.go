@@ -0,0 +1,229 @@
+package soju
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A worker failing a number of times before running until stopped.
+type runnerWorker struct {
+	mu       sync.Mutex
+	runs     int
+	failures int
+	err      error
+
+	stop chan struct{}
+	once sync.Once
+}
+
+func newRunnerWorker(failures int, err error) *runnerWorker {
+	return &runnerWorker{failures: failures, err: err, stop: make(chan struct{})}
+}
+
+func (rw *runnerWorker) Name() string {
+	return "runner"
+}
+
+func (rw *runnerWorker) Run() error {
+	rw.mu.Lock()
+	rw.runs++
+	fail := rw.failures < 0 || rw.runs <= rw.failures
+	stop := rw.stop
+	rw.mu.Unlock()
+	if fail {
+		return rw.err
+	}
+	<-stop
+	return nil
+}
+
+// Starts again after being stopped.
+func (rw *runnerWorker) Start() (err error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.stop = make(chan struct{})
+	rw.once = sync.Once{}
+	return
+}
+
+func (rw *runnerWorker) Runs() int {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.runs
+}
+
+func (rw *runnerWorker) Stop(dn DoneNotifier) (err error) {
+	rw.mu.Lock()
+	stop := rw.stop
+	rw.once.Do(func() {
+		close(stop)
+	})
+	rw.mu.Unlock()
+	dn.Done()
+	return
+}
+
+func (rw *runnerWorker) StopNow(dn DoneNotifier) (err error) {
+	return rw.Stop(dn)
+}
+
+// Waits until the worker has run n times.
+func waitRuns(rw *runnerWorker, n int) {
+	for rw.Runs() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A worker fails twice and is restarted, with a growing backoff
+// Gets terminate signal and the worker is not restarted again
+func TestSupervisorRestart(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	worker := newRunnerWorker(2, errors.New("failed"))
+	server.AddWorker(worker, Restart(RestartOnFailure), RestartBackoff(10*time.Millisecond, time.Second))
+
+	var mu sync.Mutex
+	var restarts []Event
+	server.AddEventHandler(func(event Event) {
+		if event.Type == EventRestarting {
+			mu.Lock()
+			restarts = append(restarts, event)
+			mu.Unlock()
+		}
+	})
+
+	go func() {
+		waitRuns(worker, 3)
+		server.c <- syscall.SIGTERM
+	}()
+	if result := server.Serve(time.Second, time.Second); result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+		return
+	}
+	time.Sleep(50 * time.Millisecond)
+	if worker.Runs() != 3 {
+		t.Errorf("the worker should run 3 times but ran %d", worker.Runs())
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(restarts) != 2 {
+		t.Errorf("there should be 2 restarts but there are %d", len(restarts))
+		return
+	}
+	if restarts[0].Name != "runner" || restarts[0].Err != worker.err || restarts[0].Timeout != 10*time.Millisecond || restarts[1].Timeout != 20*time.Millisecond {
+		t.Errorf("unexpected restarts %+v", restarts)
+	}
+}
+
+// A worker exits without error
+// It is restarted only if the policy is RestartAlways
+func TestSupervisorPolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy RestartPolicy
+		runs   int
+	}{
+		{RestartNever, 1},
+		{RestartOnFailure, 1},
+		{RestartAlways, 2},
+	} {
+		server := new(Server)
+		server.SetService(new(sojuTest))
+		worker := newRunnerWorker(1, nil)
+		server.AddWorker(worker, Restart(test.policy), RestartBackoff(time.Millisecond, time.Millisecond))
+
+		go func() {
+			waitRuns(worker, 1)
+			time.Sleep(50 * time.Millisecond)
+			server.c <- syscall.SIGTERM
+		}()
+		server.Serve(time.Second, time.Second)
+		if worker.Runs() != test.runs {
+			t.Errorf("with policy %d the worker should run %d times but ran %d", test.policy, test.runs, worker.Runs())
+		}
+	}
+}
+
+// A worker keeps failing
+// It exceeds its restarts and the server stops
+func TestSupervisorMaxRestarts(t *testing.T) {
+	server := new(Server)
+	notificable := new(sojuTest)
+	server.SetService(notificable)
+	worker := newRunnerWorker(-1, errors.New("failed"))
+	server.AddWorker(worker, Restart(RestartAlways), RestartBackoff(time.Millisecond, time.Millisecond), MaxRestarts(3, time.Minute))
+
+	code, report := server.ServeReport(time.Second, time.Second)
+	if code != ExitWorkerFailed {
+		t.Errorf("return code should be %d but is [%d] instead", ExitWorkerFailed, code)
+		return
+	}
+	cerr, ok := report.Cause.(*ComponentError)
+	if !ok || cerr.Name != "runner" || cerr.Phase != PhaseRun || cerr.Err != worker.err {
+		t.Errorf("unexpected cause [%v]", report.Cause)
+		return
+	}
+	if worker.Runs() != 4 {
+		t.Errorf("the worker should run 4 times but ran %d", worker.Runs())
+		return
+	}
+	if !notificable.StopCalled {
+		t.Errorf("Stop() method was not called")
+	}
+}
+
+// A worker added while serving is supervised too.
+func TestSupervisorAddWorker(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	worker := newRunnerWorker(1, errors.New("failed"))
+
+	go func() {
+		waitRunning(server)
+		server.AddWorker(worker, Restart(RestartOnFailure), RestartBackoff(time.Millisecond, time.Millisecond))
+		waitRuns(worker, 2)
+		server.c <- syscall.SIGTERM
+	}()
+	if result := server.Serve(time.Second, time.Second); result != ExitOK {
+		t.Errorf("return code should be 0 but is [%d] instead", result)
+	}
+}
+
+// A worker waits a long backoff
+// Another one is restarted after its own short backoff meanwhile
+func TestSupervisorIndependentBackoffs(t *testing.T) {
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	slow := newRunnerWorker(-1, errors.New("failed"))
+	server.AddWorker(slow, Restart(RestartAlways), RestartBackoff(time.Hour, time.Hour))
+	fast := newRunnerWorker(2, errors.New("failed"))
+	server.AddWorker(fast, Restart(RestartOnFailure), RestartBackoff(10*time.Millisecond, time.Second))
+
+	restarted := make(chan struct{})
+	go func() {
+		waitRuns(fast, 3)
+		close(restarted)
+	}()
+	go func() {
+		select {
+		case <-restarted:
+		case <-time.After(time.Second):
+		}
+		server.c <- syscall.SIGTERM
+	}()
+	server.Serve(time.Second, time.Second)
+
+	select {
+	case <-restarted:
+	default:
+		t.Errorf("the fast worker should be restarted twice but ran %d times", fast.Runs())
+		return
+	}
+	if slow.Runs() != 1 {
+		t.Errorf("the slow worker should run once but ran %d times", slow.Runs())
+	}
+}
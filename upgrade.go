@@ -0,0 +1,303 @@
+package soju
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Names of the environment variables used to pass the listeners to the new
+// process on an upgrade.
+const (
+	// Names of the listeners passed from file descriptor 3 on, separated by
+	// colons.
+	UpgradeFdNamesEnv = "SOJU_LISTEN_FDNAMES"
+	// File descriptor of the pipe used to inform the parent that the new
+	// process is ready.
+	UpgradeReadyFdEnv = "SOJU_READY_FD"
+)
+
+// Default time given to the new process to be ready on an upgrade, see
+// SetUpgradeTimeout.
+const DefaultUpgradeTimeout = time.Minute
+
+// ErrNotRunning is returned by Upgrade when the server is not running.
+var ErrNotRunning = errors.New("soju: server not running")
+
+// ErrUpgrading is returned by Upgrade when another upgrade is in progress.
+var ErrUpgrading = errors.New("soju: upgrade already in progress")
+
+// Listeners and ready pipe inherited from the parent on an upgrade.
+var (
+	upgradeFiles []*os.File
+	upgradeReady *os.File
+)
+
+// The environment is read as soon as possible so that the inherited files do
+// not leak to child processes started by the service.
+func init() {
+
+	if names := os.Getenv(UpgradeFdNamesEnv); names != "" {
+		for i, name := range strings.Split(names, ":") {
+			fd := listenFdsStart + i
+			syscall.CloseOnExec(fd)
+			upgradeFiles = append(upgradeFiles, os.NewFile(uintptr(fd), name))
+		}
+	}
+
+	if fd, err := strconv.Atoi(os.Getenv(UpgradeReadyFdEnv)); err == nil {
+		syscall.CloseOnExec(fd)
+		upgradeReady = os.NewFile(uintptr(fd), "ready")
+	}
+
+	os.Unsetenv(UpgradeFdNamesEnv)
+	os.Unsetenv(UpgradeReadyFdEnv)
+
+}
+
+// Informs the parent process, if this one was started by an upgrade, that the
+// server is ready.
+func notifyUpgradeReady() {
+
+	if upgradeReady == nil {
+		return
+	}
+
+	upgradeReady.Write([]byte{1})
+	upgradeReady.Close()
+	upgradeReady = nil
+
+	return
+
+}
+
+// Returns the command running the new process, a copy of this one by default.
+var upgradeCommand = func() (*exec.Cmd, error) {
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd, nil
+
+}
+
+// Listeners that can be passed to another process.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Registers a listener to be passed to the new process on an upgrade, where
+// it can be retrieved by its name with ActivationListener.
+func (s *Server) AddListener(name string, wl *WaitListener) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.listeners = append(s.listeners, namedListener{name: name, wl: wl})
+
+	return
+
+}
+
+// Deregisters a listener.
+func (s *Server) RemoveListener(wl *WaitListener) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range s.listeners {
+		if s.listeners[i].wl == wl {
+			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+			return
+		}
+	}
+
+	return
+
+}
+
+// Sets the time given to the new process to be ready on an upgrade, after
+// which it is killed and this server keeps serving. Zero means
+// DefaultUpgradeTimeout.
+func (s *Server) SetUpgradeTimeout(upgradeTimeout time.Duration) {
+	s.upgradeTimeout = upgradeTimeout
+	return
+}
+
+// Upgrade starts a new copy of the binary, passing it every registered
+// listener, and waits for it to be ready. Then this server is gracefully
+// stopped so that the pending connections are drained while the new process
+// accepts the new ones. If the new process fails, this one keeps serving. A
+// SIGUSR2 triggers an upgrade too. Only one upgrade runs at a time, it returns
+// ErrUpgrading if another one is in progress.
+func (s *Server) Upgrade() error {
+	return s.upgradeAndStop(syscall.SIGUSR2)
+}
+
+// Upgrades and stops the server, reporting the signal as the cause.
+func (s *Server) upgradeAndStop(sig os.Signal) error {
+
+	s.Lock()
+	running, requests, upgrading := s.running, s.stopRequests, s.upgrading
+	// No upgrades once the shutdown began.
+	if (s.root != nil && s.root.stopping) || s.stopping != nil {
+		running = false
+	}
+	if running && !upgrading {
+		s.upgrading = true
+	}
+	s.Unlock()
+
+	if !running {
+		return ErrNotRunning
+	}
+	if upgrading {
+		return ErrUpgrading
+	}
+
+	err := s.upgrade()
+
+	s.Lock()
+	s.upgrading = false
+	s.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Stop as on the signal, unless there is a stop pending.
+	select {
+	case requests <- stopRequest{sig: sig, mode: GracefulStop}:
+	default:
+	}
+
+	return nil
+
+}
+
+// Starts the new process and waits for it to be ready.
+func (s *Server) upgrade() (err error) {
+
+	s.emit(Event{Type: EventUpgrading})
+
+	var pid int
+	defer func() {
+		s.emit(Event{Type: EventUpgraded, Pid: pid, Err: err})
+	}()
+
+	s.Lock()
+	listeners := make([]namedListener, len(s.listeners))
+	copy(listeners, s.listeners)
+	s.Unlock()
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var names []string
+	for _, l := range listeners {
+		f, ok := l.wl.Listener.(filer)
+		if !ok {
+			return fmt.Errorf("soju: listener %s cannot be passed to another process", l.name)
+		}
+		file, err := f.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		names = append(names, l.name)
+	}
+
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, w)
+
+	cmd, err := upgradeCommand()
+	if err != nil {
+		return err
+	}
+	cmd.ExtraFiles = files
+	cmd.Env = append(upgradeEnv(os.Environ()),
+		UpgradeFdNamesEnv+"="+strings.Join(names, ":"),
+		UpgradeReadyFdEnv+"="+strconv.Itoa(listenFdsStart+len(names)),
+	)
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// Close our copy of the write end so that a dead child means EOF.
+	w.Close()
+	files = files[:len(files)-1]
+
+	readyc := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(ready, make([]byte, 1))
+		readyc <- err
+	}()
+
+	upgradeTimeout := s.upgradeTimeout
+	if upgradeTimeout <= 0 {
+		upgradeTimeout = DefaultUpgradeTimeout
+	}
+	timeout := s.after(upgradeTimeout)
+
+	select {
+	case err = <-readyc:
+		if err != nil {
+			cmd.Wait()
+			return fmt.Errorf("soju: upgraded process exited before being ready: %v", err)
+		}
+	case <-timeout:
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.New("soju: timeout waiting for the upgraded process")
+	}
+
+	pid = cmd.Process.Pid
+	// The new process outlives this one.
+	cmd.Process.Release()
+
+	return nil
+
+}
+
+// Removes the inherited sockets variables from the environment.
+func upgradeEnv(environ []string) (env []string) {
+
+	for _, kv := range environ {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case UpgradeFdNamesEnv, UpgradeReadyFdEnv, ListenPidEnv, ListenFdsEnv, ListenFdNamesEnv:
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	return
+
+}
+
+// A listener registered to be passed on upgrades.
+type namedListener struct {
+	name string
+	wl   *WaitListener
+}
@@ -0,0 +1,288 @@
+package soju
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tekii/soju/sojutest"
+)
+
+// Set for the process started by TestUpgrade.
+const upgradeChildEnv = "SOJU_TEST_UPGRADE_CHILD"
+
+// Runs this test binary as the upgraded process.
+func testUpgradeCommand(test string) func() (*exec.Cmd, error) {
+	return func() (*exec.Cmd, error) {
+		return exec.Command(os.Args[0], "-test.run=^"+test+"$"), nil
+	}
+}
+
+// A service closing its listener on Stop.
+type listenerService struct {
+	sojuTest
+	wl *WaitListener
+}
+
+func (ls *listenerService) Stop(dn DoneNotifier) (err error) {
+	err = ls.wl.Close()
+	ls.wl.WaitGroup.Wait()
+	dn.Done()
+	return
+}
+
+// Run as the upgraded process by TestUpgrade:
+// gets the listener from the parent, serves one connection and stops.
+func TestUpgradeChild(t *testing.T) {
+	if os.Getenv(upgradeChildEnv) == "" {
+		t.Skip("run by TestUpgrade")
+	}
+	wl, err := ActivationListener("http", new(sync.WaitGroup))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := new(Server)
+	server.SetService(&listenerService{wl: wl})
+	server.SetTimeouts(time.Second, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if conn, err := wl.Accept(); err == nil {
+			conn.Write([]byte("child"))
+			conn.Close()
+		}
+		cancel()
+	}()
+	if err := server.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Registers a listener
+// Gets user signal 2
+// Starts the new process and, once it is ready, stops gracefully
+// The new process keeps accepting connections on the listener
+func TestUpgrade(t *testing.T) {
+	if os.Getenv(upgradeChildEnv) != "" {
+		t.Skip("running as the upgraded process")
+	}
+	defer func(cmd func() (*exec.Cmd, error)) {
+		upgradeCommand = cmd
+	}(upgradeCommand)
+	upgradeCommand = testUpgradeCommand("TestUpgradeChild")
+	os.Setenv(upgradeChildEnv, "1")
+	defer os.Unsetenv(upgradeChildEnv)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl := &WaitListener{Listener: l, WaitGroup: new(sync.WaitGroup)}
+
+	server := new(Server)
+	server.SetService(&listenerService{wl: wl})
+	server.AddListener("http", wl)
+	server.SetUpgradeTimeout(10 * time.Second)
+	server.SetTimeouts(time.Second, time.Second)
+	upgraded := make(chan Event, 1)
+	server.AddEventHandler(func(event Event) {
+		if event.Type == EventUpgraded {
+			upgraded <- event
+		}
+	})
+
+	go func() {
+		waitRunning(server)
+		server.c <- syscall.SIGUSR2
+	}()
+	report, err := server.RunReport(context.Background())
+	if err != nil {
+		t.Errorf("RunReport() should return nil but returned [%v]", err)
+		return
+	}
+	if report.Signal != syscall.SIGUSR2 {
+		t.Errorf("the server should stop on SIGUSR2 but stopped on [%v]", report.Signal)
+		return
+	}
+	event := <-upgraded
+	if event.Err != nil || event.Pid == 0 {
+		t.Errorf("unexpected upgrade event %+v", event)
+		return
+	}
+
+	// This server closed its listener, the new process answers.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Errorf("the new process should accept connections but got [%v]", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	answer, err := ioutil.ReadAll(conn)
+	if err != nil || string(answer) != "child" {
+		t.Errorf("the new process should answer but got %q [%v]", answer, err)
+	}
+}
+
+// The new process exits without being ready
+// The upgrade fails and the server keeps serving
+func TestUpgradeFailed(t *testing.T) {
+	defer func(cmd func() (*exec.Cmd, error)) {
+		upgradeCommand = cmd
+	}(upgradeCommand)
+	// Runs no test and exits.
+	upgradeCommand = testUpgradeCommand("NoTest")
+
+	server := new(Server)
+	notificable := new(sojuTest)
+	server.SetService(notificable)
+	server.SetTimeouts(time.Second, time.Second)
+
+	if err := server.Upgrade(); err != ErrNotRunning {
+		t.Errorf("Upgrade() should return ErrNotRunning but returned [%v]", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+	waitRunning(server)
+
+	if err := server.Upgrade(); err == nil {
+		t.Errorf("Upgrade() should fail")
+	}
+	select {
+	case err := <-done:
+		t.Errorf("the server should keep serving but returned [%v]", err)
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+	if notificable.StopCalled {
+		t.Errorf("Stop() method shouldn't be called")
+	}
+
+	cancel()
+	<-done
+}
+
+// The inherited sockets variables are not passed to the new process.
+func TestUpgradeEnv(t *testing.T) {
+	env := upgradeEnv([]string{"PATH=/bin", "LISTEN_FDS=2", "SOJU_LISTEN_FDNAMES=http", "SOJU_READY_FD=4", "HOME=/root"})
+	if len(env) != 2 || env[0] != "PATH=/bin" || env[1] != "HOME=/root" {
+		t.Errorf("unexpected environment %v", env)
+	}
+}
+
+// The new process hangs without being ready
+// Another upgrade is refused and a terminate signal still stops the server
+func TestUpgradeInProgress(t *testing.T) {
+	defer func(cmd func() (*exec.Cmd, error)) {
+		upgradeCommand = cmd
+	}(upgradeCommand)
+	upgradeCommand = func() (*exec.Cmd, error) {
+		return exec.Command("sleep", "10"), nil
+	}
+
+	server := new(Server)
+	server.SetService(new(sojuTest))
+	server.SetTimeouts(time.Second, time.Second)
+	server.SetUpgradeTimeout(500 * time.Millisecond)
+	events := make(chan Event, 2)
+	server.AddEventHandler(func(event Event) {
+		if event.Type == EventUpgrading || event.Type == EventUpgraded {
+			events <- event
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(context.Background())
+	}()
+	waitRunning(server)
+	server.c <- syscall.SIGUSR2
+	<-events
+
+	if err := server.Upgrade(); err != ErrUpgrading {
+		t.Errorf("Upgrade() should return ErrUpgrading but returned [%v]", err)
+		return
+	}
+
+	server.c <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() should return nil but returned [%v]", err)
+			return
+		}
+	case <-time.After(250 * time.Millisecond):
+		t.Errorf("the server should stop while upgrading")
+		<-done
+		return
+	}
+
+	if event := <-events; event.Err == nil {
+		t.Errorf("the upgrade should time out")
+	}
+}
+
+// The new process hangs without being ready and no upgrade timeout is set
+// The upgrade fails after the default timeout and the server keeps serving
+func TestUpgradeDefaultTimeout(t *testing.T) {
+	defer func(cmd func() (*exec.Cmd, error)) {
+		upgradeCommand = cmd
+	}(upgradeCommand)
+	upgradeCommand = func() (*exec.Cmd, error) {
+		return exec.Command("sleep", "10"), nil
+	}
+
+	clock := sojutest.NewFakeClock(epoch)
+	server := new(Server)
+	server.SetClock(clock)
+	server.SetService(new(sojuTest))
+	server.SetTimeouts(time.Second, time.Second)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(context.Background())
+	}()
+	waitRunning(server)
+
+	upgraded := make(chan error, 1)
+	go func() {
+		upgraded <- server.Upgrade()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(DefaultUpgradeTimeout)
+
+	select {
+	case err := <-upgraded:
+		if err == nil || err == ErrUpgrading {
+			t.Errorf("the upgrade should time out but returned [%v]", err)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("the upgrade should time out after DefaultUpgradeTimeout")
+		return
+	}
+	select {
+	case err := <-done:
+		t.Errorf("the server should keep serving but Run() returned [%v]", err)
+		return
+	default:
+	}
+
+	server.c <- syscall.SIGTERM
+	if err := <-done; err != nil {
+		t.Errorf("Run() should return nil but returned [%v]", err)
+	}
+}
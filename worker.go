@@ -4,3 +4,17 @@ type Worker interface {
 	Stop(DoneNotifier) error
 	StopNow(DoneNotifier) error
 }
+
+// Reconfigurer is an optional interface for workers that are able to reload
+// their configuration. The server calls Reconfigure() on every registered
+// worker implementing it when a SIGHUP is received.
+type Reconfigurer interface {
+	Reconfigure() error
+}
+
+// Reopener is an optional interface for the service and workers that write to
+// files that may be rotated, like logs. The server calls Reopen() on all of them
+// when a signal bound to ActionReopen (SIGUSR1 by default) is received.
+type Reopener interface {
+	Reopen() error
+}